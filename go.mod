@@ -1,8 +1,9 @@
 module github.com/nachorpaez/osquery-extensions
 
-go 1.23.5
+go 1.24.0
 
 require (
+	github.com/apache/thrift v0.16.0
 	github.com/golang/glog v1.2.4
 	github.com/osquery/osquery-go v0.0.0-20231130195733-61ac79279aaa
 	github.com/pkg/errors v0.9.1
@@ -11,7 +12,6 @@ require (
 
 require (
 	github.com/Microsoft/go-winio v0.4.9 // indirect
-	github.com/apache/thrift v0.16.0 // indirect
 	github.com/davecgh/go-spew v1.1.1 // indirect
 	github.com/go-logr/logr v1.2.4 // indirect
 	github.com/go-logr/stdr v1.2.2 // indirect
@@ -1,69 +1,105 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"log"
 	"os"
+	"os/signal"
+	"runtime"
+	"syscall"
 	"time"
 
 	"github.com/golang/glog"
-	"github.com/nachorpaez/osquery-extensions/tables/chrome_extensions_dns"
-	"github.com/nachorpaez/osquery-extensions/tables/chrome_preferences"
+	"github.com/nachorpaez/osquery-extensions/pkg/registry"
+	"github.com/nachorpaez/osquery-extensions/pkg/runner"
+	"github.com/nachorpaez/osquery-extensions/pkg/utils"
+	_ "github.com/nachorpaez/osquery-extensions/tables/all"
+	"github.com/nachorpaez/osquery-extensions/tables/extension_info"
 	osquery "github.com/osquery/osquery-go"
-	"github.com/osquery/osquery-go/plugin/table"
 )
 
 var packageVersion string // This variable get's set by the build process
 
 func main() {
+	// Standalone mode, runs a table without osqueryd
+	if len(os.Args) > 1 && os.Args[1] == "query" {
+		os.Exit(runQuery(os.Args[2:], os.Stdout, os.Stderr))
+	}
+
 	var (
 		flSocketPath = flag.String("socket", "", "")
 		flTimeout    = flag.Int("timeout", 0, "")
 		_            = flag.Int("interval", 0, "")
 		_            = flag.Bool("verbose", false, "")
+		flRoot       = flag.String("root", "", "Directory prefixed to every path, e.g. a mounted disk image")
+		flTables     = flag.String("tables", "", "Comma separated list of tables to register, all by default")
+		flDisabled   = flag.String("disable-tables", "", "Comma separated list of tables not to register")
+		flSocketWait = flag.Duration("socket-wait", time.Minute, "How long to wait for the osquery socket to be ready before giving up")
+		flCacheSize  = flag.Int64("cache-size", utils.DefaultParsedCacheSize>>20, "Memory cap of the parsed file cache in MB, 0 disables the cache")
+		flTableTime  = flag.Duration("table-timeout", registry.DefaultTimeout, "Deadline of a table's query, partial results are returned past it")
+		flCacheTTL   = flag.Duration("cache-ttl", 0, "Maximum time a parsed file is reused while it doesn't change, no limit by default")
+		flMaxSize    = flag.Int64("max-file-size", utils.DefaultMaxFileSize>>20, "Size in MB over which a file is not read, 0 removes the limit")
 	)
 	flag.Parse()
 	defer glog.Flush()
 
-	// allow for osqueryd to create the socket path otherwise it will error
-	time.Sleep(3 * time.Second)
+	utils.SetRootDir(*flRoot)
+	// Unchanged files aren't parsed again by every query
+	if *flCacheSize > 0 {
+		utils.SetParsedCache(utils.NewParsedCache(*flCacheSize<<20, *flCacheTTL))
+	} else {
+		utils.SetParsedCache(nil)
+	}
+	// The files of every user are read, a user mustn't be able to make the
+	// extension read huge ones
+	utils.SetMaxFileSize(*flMaxSize << 20)
+	// Resolve accounts through osquery's users table
+	utils.SetOsqueryClienter(&utils.SocketOsqueryClienter{
+		SocketPath: *flSocketPath,
+		Timeout:    time.Duration(*flTimeout) * time.Second,
+	})
 
-	server, err := osquery.NewExtensionManagerServer(
-		"osquery_extension",
-		*flSocketPath,
-		osquery.ServerTimeout(time.Duration(*flTimeout)*time.Second),
-		osquery.ExtensionVersion(packageVersion),
-	)
+	// A slow home directory, e.g. on NFS, must not stall osqueryd
+	registry.SetDefaultTimeout(*flTableTime)
+
+	tables, err := registry.Select(runtime.GOOS, registry.ParseList(*flTables), registry.ParseList(*flDisabled))
 	if err != nil {
-		log.Fatalf("Error creating extension: %s\n", err)
+		log.Fatalf("Error selecting tables: %s\n", err)
 	}
 
-	// Create and register a new table plugin with the server.
-	// Adding a new table? Add it to the list and the loop below will handle
-	// the registration for you.
-	plugins := []osquery.OsqueryPlugin{
-		table.NewPlugin("chrome_preferences", chrome_preferences.GoogleChromePreferencesColumns(), chrome_preferences.GoogleChromePreferencesGenerate),
-		table.NewPlugin("chrome_extensions_dns", chrome_extensions_dns.ChromeExtensionsDNSColumns(), chrome_extensions_dns.ChromeExtensionsDNSGenerate),
+	var tableNames []string
+	for _, t := range tables {
+		tableNames = append(tableNames, t.Name)
 	}
+	extension_info.Configure(packageVersion, *flSocketPath, tableNames)
 
-	// Platform specific tables
-	// if runtime.GOOS == "windows" {
-	// If there were windows only tables, they would go here
-	// }
-
-	// if runtime.GOOS == "darwin" {
-	// 	darwinPlugins := []osquery.OsqueryPlugin{
-	// 	}
-	// 	plugins = append(plugins, darwinPlugins...)
-	// }
-
-	for _, p := range plugins {
-		server.RegisterPlugin(p)
+	// Tables register themselves with the registry, adding a new one only
+	// requires importing its package in tables/all. Platform specific tables
+	// declare the platforms they support.
+	var plugins []osquery.OsqueryPlugin
+	for _, t := range tables {
+		plugins = append(plugins, t.Plugin())
 	}
 
-	// Start the server. It will run forever unless an error bubbles up.
-	if err := server.Run(); err != nil {
+	// Shutdown gracefully, deregistering the extension, when asked to stop
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Wait for osqueryd to create the socket and register again whenever it
+	// restarts. It will run until a signal is received unless the socket
+	// doesn't come back.
+	err = runner.Run(ctx, runner.Config{
+		Name:       "osquery_extension",
+		Version:    packageVersion,
+		SocketPath: *flSocketPath,
+		Plugins:    plugins,
+		Timeout:    time.Duration(*flTimeout) * time.Second,
+		SocketWait: *flSocketWait,
+	})
+	if err != nil {
 		glog.Errorln(err)
+		glog.Flush()
 		os.Exit(1)
 	}
 }
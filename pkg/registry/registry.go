@@ -0,0 +1,179 @@
+// Package registry holds the tables provided by this extension. Table
+// packages register themselves from an init function, so importing a table
+// package (or tables/all for every table) is enough to make it available:
+//
+//	import (
+//		"github.com/nachorpaez/osquery-extensions/pkg/registry"
+//		_ "github.com/nachorpaez/osquery-extensions/tables/all"
+//	)
+//
+//	for _, t := range registry.Tables() {
+//		server.RegisterPlugin(t.Plugin())
+//	}
+package registry
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/osquery/osquery-go/plugin/table"
+	"github.com/pkg/errors"
+)
+
+// Table describes an osquery table
+type Table struct {
+	Name     string
+	Columns  []table.ColumnDefinition
+	Generate table.GenerateFunc
+	// Platforms lists the GOOS values the table supports. An empty list
+	// means every platform.
+	Platforms []string
+	// Timeout is the deadline of the context given to Generate, the default
+	// timeout is used when zero
+	Timeout time.Duration
+}
+
+// DefaultTimeout is the deadline of a table's generate function unless the
+// table sets its own
+const DefaultTimeout = 10 * time.Second
+
+var (
+	mu             sync.RWMutex
+	tables         = map[string]Table{}
+	defaultTimeout = DefaultTimeout
+)
+
+// SetDefaultTimeout sets the deadline of the tables without their own
+// timeout. A zero timeout removes the deadline.
+func SetDefaultTimeout(timeout time.Duration) {
+	mu.Lock()
+	defer mu.Unlock()
+	defaultTimeout = timeout
+}
+
+// timeoutFor returns the deadline of a table's generate function
+func timeoutFor(t Table) time.Duration {
+	if t.Timeout > 0 {
+		return t.Timeout
+	}
+	mu.RLock()
+	defer mu.RUnlock()
+	return defaultTimeout
+}
+
+// Register adds a table to the registry. Its generate function is wrapped to
+// collect statistics and apply the table's deadline. It panics if the table
+// is incomplete or a table with the same name is already registered.
+func Register(t Table) {
+	if t.Name == "" || len(t.Columns) == 0 || t.Generate == nil {
+		panic(fmt.Sprintf("registry: incomplete definition for table %q", t.Name))
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if _, ok := tables[t.Name]; ok {
+		panic(fmt.Sprintf("registry: table %q registered twice", t.Name))
+	}
+	// Collect statistics for every table, see GetStats
+	t.Generate = instrument(t, t.Generate)
+	tables[t.Name] = t
+}
+
+// Tables returns every registered table sorted by name
+func Tables() []Table {
+	mu.RLock()
+	defer mu.RUnlock()
+	result := make([]Table, 0, len(tables))
+	for _, t := range tables {
+		result = append(result, t)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	return result
+}
+
+// Names returns the names of every registered table sorted
+func Names() []string {
+	var names []string
+	for _, t := range Tables() {
+		names = append(names, t.Name)
+	}
+	return names
+}
+
+// Lookup returns the table registered under name
+func Lookup(name string) (Table, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+	t, ok := tables[name]
+	return t, ok
+}
+
+// SupportsPlatform reports whether the table can run on goos
+func (t Table) SupportsPlatform(goos string) bool {
+	if len(t.Platforms) == 0 {
+		return true
+	}
+	for _, p := range t.Platforms {
+		if p == goos {
+			return true
+		}
+	}
+	return false
+}
+
+// Plugin returns the osquery plugin serving the table
+func (t Table) Plugin() *table.Plugin {
+	return table.NewPlugin(t.Name, t.Columns, t.Generate)
+}
+
+// Select returns the tables supported on goos, restricted to enabled when it
+// is not empty and without the ones in disabled. Unknown table names are an
+// error so a typo doesn't silently change what a host exposes.
+func Select(goos string, enabled, disabled []string) ([]Table, error) {
+	enabledSet, err := nameSet(enabled)
+	if err != nil {
+		return nil, err
+	}
+	disabledSet, err := nameSet(disabled)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []Table
+	for _, t := range Tables() {
+		if len(enabledSet) > 0 && !enabledSet[t.Name] {
+			continue
+		}
+		if disabledSet[t.Name] || !t.SupportsPlatform(goos) {
+			continue
+		}
+		result = append(result, t)
+	}
+	return result, nil
+}
+
+// ParseList splits a comma separated list of table names, as given to the
+// --tables and --disable-tables flags
+func ParseList(list string) []string {
+	var names []string
+	for _, name := range strings.Split(list, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+func nameSet(names []string) (map[string]bool, error) {
+	set := map[string]bool{}
+	for _, name := range names {
+		if _, ok := Lookup(name); !ok {
+			return nil, errors.Errorf("unknown table %q", name)
+		}
+		set[name] = true
+	}
+	return set, nil
+}
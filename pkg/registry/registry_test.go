@@ -0,0 +1,145 @@
+package registry
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/nachorpaez/osquery-extensions/pkg/utils"
+	"github.com/osquery/osquery-go/plugin/table"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testTable(name string, platforms ...string) Table {
+	return Table{
+		Name:    name,
+		Columns: []table.ColumnDefinition{table.TextColumn("value")},
+		Generate: func(ctx context.Context, queryContext table.QueryContext) ([]map[string]string, error) {
+			return []map[string]string{{"value": name}}, nil
+		},
+		Platforms: platforms,
+	}
+}
+
+func TestSelect(t *testing.T) {
+	Register(testTable("test_everywhere"))
+	Register(testTable("test_darwin", "darwin"))
+	Register(testTable("test_windows", "windows", "darwin"))
+
+	names := func(tables []Table) []string {
+		var result []string
+		for _, t := range tables {
+			result = append(result, t.Name)
+		}
+		return result
+	}
+
+	selected, err := Select("darwin", nil, nil)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"test_darwin", "test_everywhere", "test_windows"}, names(selected))
+
+	selected, err = Select("linux", nil, nil)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"test_everywhere"}, names(selected))
+
+	selected, err = Select("darwin", nil, []string{"test_darwin"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"test_everywhere", "test_windows"}, names(selected))
+
+	selected, err = Select("windows", []string{"test_windows", "test_darwin"}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"test_windows"}, names(selected))
+
+	_, err = Select("darwin", []string{"test_typo"}, nil)
+	assert.Error(t, err)
+	_, err = Select("darwin", nil, []string{"test_typo"})
+	assert.Error(t, err)
+
+	found, ok := Lookup("test_darwin")
+	assert.True(t, ok)
+	assert.Equal(t, "test_darwin", found.Plugin().Name())
+}
+
+func TestRegisterInvalid(t *testing.T) {
+	Register(testTable("test_duplicate"))
+	assert.Panics(t, func() { Register(testTable("test_duplicate")) })
+	assert.Panics(t, func() { Register(Table{Name: "test_incomplete"}) })
+}
+
+func TestParseList(t *testing.T) {
+	assert.Equal(t, []string{"chrome_preferences", "vscode_settings"}, ParseList(" chrome_preferences,,vscode_settings "))
+	assert.Nil(t, ParseList(""))
+}
+
+func TestStats(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "data")
+	require.NoError(t, os.WriteFile(file, []byte("0123456789"), 0600))
+
+	Register(Table{
+		Name:    "test_stats",
+		Columns: []table.ColumnDefinition{table.TextColumn("value")},
+		Generate: func(ctx context.Context, queryContext table.QueryContext) ([]map[string]string, error) {
+			for i := 0; i < 2; i++ {
+				if _, err := utils.ReadFile(ctx, file); err != nil {
+					return nil, err
+				}
+			}
+			return []map[string]string{{"value": "a"}, {"value": "b"}, {"value": "c"}}, nil
+		},
+	})
+
+	registered, ok := Lookup("test_stats")
+	require.True(t, ok)
+	for i := 0; i < 3; i++ {
+		_, err := registered.Generate(context.Background(), table.QueryContext{})
+		require.NoError(t, err)
+	}
+
+	stats := GetStats("test_stats")
+	assert.Equal(t, int64(3), stats.Invocations)
+	assert.Equal(t, 3, stats.LastRowCount)
+	assert.Equal(t, int64(2), stats.FilesRead)
+	assert.Equal(t, int64(20), stats.BytesParsed)
+	assert.GreaterOrEqual(t, stats.P95Duration, stats.LastDuration/2)
+
+	assert.Equal(t, Stats{}, GetStats("test_unknown"))
+}
+
+func TestPercentile(t *testing.T) {
+	var durations []time.Duration
+	for i := 1; i <= 100; i++ {
+		durations = append(durations, time.Duration(101-i)*time.Millisecond)
+	}
+	assert.Equal(t, 95*time.Millisecond, percentile(durations, 95))
+	assert.Equal(t, 3*time.Millisecond, percentile([]time.Duration{3 * time.Millisecond}, 95))
+	assert.Equal(t, time.Duration(0), percentile(nil, 95))
+}
+
+func TestTimeout(t *testing.T) {
+	deadlines := map[string]time.Duration{}
+	generate := func(name string) table.GenerateFunc {
+		return func(ctx context.Context, queryContext table.QueryContext) ([]map[string]string, error) {
+			deadline, ok := ctx.Deadline()
+			require.True(t, ok)
+			deadlines[name] = time.Until(deadline)
+			return nil, nil
+		}
+	}
+	Register(Table{Name: "test_timeout_default", Columns: []table.ColumnDefinition{table.TextColumn("value")}, Generate: generate("default")})
+	Register(Table{Name: "test_timeout_own", Columns: []table.ColumnDefinition{table.TextColumn("value")}, Generate: generate("own"), Timeout: time.Minute})
+
+	SetDefaultTimeout(time.Second)
+	t.Cleanup(func() { SetDefaultTimeout(DefaultTimeout) })
+	for _, name := range []string{"test_timeout_default", "test_timeout_own"} {
+		registered, ok := Lookup(name)
+		require.True(t, ok)
+		_, err := registered.Generate(context.Background(), table.QueryContext{})
+		require.NoError(t, err)
+	}
+
+	assert.LessOrEqual(t, deadlines["default"], time.Second)
+	assert.Greater(t, deadlines["own"], time.Second)
+}
@@ -0,0 +1,108 @@
+package registry
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/nachorpaez/osquery-extensions/pkg/utils"
+	"github.com/osquery/osquery-go/plugin/table"
+)
+
+// durationWindow is the number of invocations the p95 duration is computed on
+const durationWindow = 100
+
+// Stats describes the invocations of a table's generate function
+type Stats struct {
+	Invocations  int64
+	LastDuration time.Duration
+	P95Duration  time.Duration
+	LastRowCount int
+	// Files read and bytes parsed during the last invocation
+	FilesRead   int64
+	BytesParsed int64
+	// Lookups of the parsed file cache during the last invocation
+	CacheHits   int64
+	CacheMisses int64
+}
+
+type tableStats struct {
+	mu        sync.Mutex
+	stats     Stats
+	durations []time.Duration
+}
+
+var (
+	statsMu  sync.Mutex
+	allStats = map[string]*tableStats{}
+)
+
+func (s *tableStats) record(duration time.Duration, rows int, reads *utils.ReadStats) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.stats.Invocations++
+	s.stats.LastDuration = duration
+	s.stats.LastRowCount = rows
+	s.stats.FilesRead = reads.Files.Load()
+	s.stats.BytesParsed = reads.Bytes.Load()
+	s.stats.CacheHits = reads.CacheHits.Load()
+	s.stats.CacheMisses = reads.CacheMisses.Load()
+
+	if len(s.durations) == durationWindow {
+		s.durations = s.durations[1:]
+	}
+	s.durations = append(s.durations, duration)
+	s.stats.P95Duration = percentile(s.durations, 95)
+}
+
+// percentile returns the nearest-rank percentile of the durations
+func percentile(durations []time.Duration, p int) time.Duration {
+	if len(durations) == 0 {
+		return 0
+	}
+	sorted := append([]time.Duration{}, durations...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	rank := (p*len(sorted) + 99) / 100
+	return sorted[rank-1]
+}
+
+// instrument wraps a generate function to collect its statistics and give it
+// the table's deadline and name
+func instrument(t Table, generate table.GenerateFunc) table.GenerateFunc {
+	statsMu.Lock()
+	s, ok := allStats[t.Name]
+	if !ok {
+		s = &tableStats{}
+		allStats[t.Name] = s
+	}
+	statsMu.Unlock()
+
+	return func(ctx context.Context, queryContext table.QueryContext) ([]map[string]string, error) {
+		if timeout := timeoutFor(t); timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+		reads := &utils.ReadStats{}
+		start := time.Now()
+		ctx = utils.WithTableName(utils.WithReadStats(ctx, reads), t.Name)
+		rows, err := generate(ctx, queryContext)
+		s.record(time.Since(start), len(rows), reads)
+		return rows, err
+	}
+}
+
+// GetStats returns the statistics of a registered table
+func GetStats(name string) Stats {
+	statsMu.Lock()
+	s, ok := allStats[name]
+	statsMu.Unlock()
+	if !ok {
+		return Stats{}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.stats
+}
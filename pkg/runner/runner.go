@@ -0,0 +1,165 @@
+// Package runner keeps the extension registered with the osquery extension
+// manager. It waits for the manager socket to be ready, registers the
+// plugins, and registers them again when osqueryd restarts.
+package runner
+
+import (
+	"context"
+	"log"
+	"time"
+
+	osquery "github.com/osquery/osquery-go"
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultInitialBackoff = 200 * time.Millisecond
+	defaultMaxBackoff     = 5 * time.Second
+	defaultSocketWait     = time.Minute
+	// Same as osquery-go, a zero timeout fails before the socket is dialed
+	defaultTimeout = time.Second
+	// Time given to the server to deregister and stop on shutdown
+	shutdownTimeout = 5 * time.Second
+)
+
+// Config describes the extension to run
+type Config struct {
+	Name       string
+	Version    string
+	SocketPath string
+	Plugins    []osquery.OsqueryPlugin
+	// Timeout of the thrift calls to the extension manager, one second when
+	// zero
+	Timeout time.Duration
+	// PingInterval is how often the extension manager is pinged to notice
+	// it went away. The osquery-go default is used when zero.
+	PingInterval time.Duration
+	// SocketWait is how long to wait for the extension manager to be ready,
+	// on start and after it went away, before giving up
+	SocketWait time.Duration
+	// Backoff between attempts to reach the extension manager
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+}
+
+func (c *Config) setDefaults() {
+	if c.Timeout <= 0 {
+		c.Timeout = defaultTimeout
+	}
+	if c.SocketWait <= 0 {
+		c.SocketWait = defaultSocketWait
+	}
+	if c.InitialBackoff <= 0 {
+		c.InitialBackoff = defaultInitialBackoff
+	}
+	if c.MaxBackoff <= 0 {
+		c.MaxBackoff = defaultMaxBackoff
+	}
+}
+
+func (c *Config) serverOptions() []osquery.ServerOption {
+	opts := []osquery.ServerOption{
+		osquery.ServerTimeout(c.Timeout),
+		osquery.ExtensionVersion(c.Version),
+	}
+	if c.PingInterval > 0 {
+		opts = append(opts, osquery.ServerPingInterval(c.PingInterval))
+	}
+	return opts
+}
+
+// connect creates an extension manager server, retrying with an exponential
+// backoff until the socket accepts connections or SocketWait elapses. The
+// returned client has to be closed once the server stopped.
+func connect(ctx context.Context, config Config) (*osquery.ExtensionManagerServer, *osquery.ExtensionManagerClient, error) {
+	deadline := time.Now().Add(config.SocketWait)
+	backoff := config.InitialBackoff
+
+	for {
+		// The client is created here to tell a socket that isn't ready
+		// apart from an invalid configuration
+		client, err := osquery.NewClient(config.SocketPath, config.Timeout)
+		if err == nil {
+			opts := append(config.serverOptions(), osquery.WithClient(client))
+			server, err := osquery.NewExtensionManagerServer(config.Name, config.SocketPath, opts...)
+			if err != nil {
+				client.Close()
+				return nil, nil, err
+			}
+			return server, client, nil
+		}
+		if time.Now().Add(backoff).After(deadline) {
+			return nil, nil, errors.Wrapf(err, "extension manager not ready after %s", config.SocketWait)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, nil, ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > config.MaxBackoff {
+			backoff = config.MaxBackoff
+		}
+	}
+}
+
+// Run registers the plugins with the extension manager and serves osquery
+// requests until ctx is cancelled, which shuts the server down gracefully.
+// When the extension manager goes away, e.g. osqueryd restarts, a new server
+// is created and registered once the socket is back. Run only returns an
+// error if the extension manager isn't ready within SocketWait.
+func Run(ctx context.Context, config Config) error {
+	config.setDefaults()
+	retryBackoff := config.InitialBackoff
+
+	for {
+		server, client, err := connect(ctx, config)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		server.RegisterPlugin(config.Plugins...)
+
+		started := time.Now()
+		errc := make(chan error, 1)
+		go func() {
+			errc <- server.Run()
+		}()
+
+		select {
+		case <-ctx.Done():
+			shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+			if err := server.Shutdown(shutdownCtx); err != nil {
+				log.Printf("Error shutting down extension: %s", err)
+			}
+			cancel()
+			select {
+			case <-errc:
+			case <-time.After(shutdownTimeout):
+			}
+			client.Close()
+			return nil
+		case err := <-errc:
+			// server.Run shuts the server down before returning
+			client.Close()
+			log.Printf("Extension manager went away, reconnecting: %v", err)
+		}
+
+		// Don't spin if the manager keeps rejecting the extension
+		if time.Since(started) > config.MaxBackoff {
+			retryBackoff = config.InitialBackoff
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(retryBackoff):
+		}
+		retryBackoff *= 2
+		if retryBackoff > config.MaxBackoff {
+			retryBackoff = config.MaxBackoff
+		}
+	}
+}
@@ -0,0 +1,174 @@
+//go:build !windows
+// +build !windows
+
+package runner
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/apache/thrift/lib/go/thrift"
+	osquerygo "github.com/osquery/osquery-go"
+	"github.com/osquery/osquery-go/gen/osquery"
+	"github.com/osquery/osquery-go/plugin/table"
+	"github.com/osquery/osquery-go/transport"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeManager is an extension manager served over a local thrift socket,
+// standing in for osqueryd
+type fakeManager struct {
+	mu              sync.Mutex
+	registrations   int
+	deregistrations int
+	server          *thrift.TSimpleServer
+	path            string
+}
+
+func (m *fakeManager) Ping(ctx context.Context) (*osquery.ExtensionStatus, error) {
+	return &osquery.ExtensionStatus{Code: 0, Message: "OK"}, nil
+}
+
+func (m *fakeManager) Call(ctx context.Context, registry string, item string, request osquery.ExtensionPluginRequest) (*osquery.ExtensionResponse, error) {
+	return &osquery.ExtensionResponse{Status: &osquery.ExtensionStatus{Code: 0}}, nil
+}
+
+func (m *fakeManager) Shutdown(ctx context.Context) error {
+	return nil
+}
+
+func (m *fakeManager) Extensions(ctx context.Context) (osquery.InternalExtensionList, error) {
+	return osquery.InternalExtensionList{}, nil
+}
+
+func (m *fakeManager) Options(ctx context.Context) (osquery.InternalOptionList, error) {
+	return osquery.InternalOptionList{}, nil
+}
+
+func (m *fakeManager) RegisterExtension(ctx context.Context, info *osquery.InternalExtensionInfo, registry osquery.ExtensionRegistry) (*osquery.ExtensionStatus, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.registrations++
+	return &osquery.ExtensionStatus{Code: 0, UUID: osquery.ExtensionRouteUUID(m.registrations)}, nil
+}
+
+func (m *fakeManager) DeregisterExtension(ctx context.Context, uuid osquery.ExtensionRouteUUID) (*osquery.ExtensionStatus, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.deregistrations++
+	return &osquery.ExtensionStatus{Code: 0}, nil
+}
+
+func (m *fakeManager) Query(ctx context.Context, sql string) (*osquery.ExtensionResponse, error) {
+	return &osquery.ExtensionResponse{Status: &osquery.ExtensionStatus{Code: 0}}, nil
+}
+
+func (m *fakeManager) GetQueryColumns(ctx context.Context, sql string) (*osquery.ExtensionResponse, error) {
+	return &osquery.ExtensionResponse{Status: &osquery.ExtensionStatus{Code: 0}}, nil
+}
+
+func (m *fakeManager) counts() (int, int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.registrations, m.deregistrations
+}
+
+// start serves the manager on path, as osqueryd creating its socket
+func (m *fakeManager) start(t *testing.T, path string) {
+	socket, err := transport.OpenServer(path, time.Second)
+	require.NoError(t, err)
+	require.NoError(t, socket.Listen())
+	m.path = path
+	m.server = thrift.NewTSimpleServer2(osquery.NewExtensionManagerProcessor(m), socket)
+	go m.server.Serve()
+}
+
+// stop closes the socket, as osqueryd going away. It returns once every
+// connection to the manager is closed.
+func (m *fakeManager) stop() {
+	m.server.Stop()
+	os.Remove(m.path)
+}
+
+func testConfig(socketPath string) Config {
+	return Config{
+		Name:       "test_extension",
+		SocketPath: socketPath,
+		Plugins: []osquerygo.OsqueryPlugin{
+			table.NewPlugin("test_table", []table.ColumnDefinition{table.TextColumn("value")},
+				func(ctx context.Context, queryContext table.QueryContext) ([]map[string]string, error) {
+					return nil, nil
+				}),
+		},
+		Timeout:        time.Second,
+		PingInterval:   50 * time.Millisecond,
+		SocketWait:     5 * time.Second,
+		InitialBackoff: 10 * time.Millisecond,
+		MaxBackoff:     100 * time.Millisecond,
+	}
+}
+
+// socketPath returns a path short enough for a unix socket
+func socketPath(t *testing.T) string {
+	dir, err := os.MkdirTemp("", "runner")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return filepath.Join(dir, "osquery.em")
+}
+
+func TestRunReconnectsAndShutsDown(t *testing.T) {
+	path := socketPath(t)
+	manager := &fakeManager{}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		done <- Run(ctx, testConfig(path))
+	}()
+
+	// The socket shows up after the extension started
+	time.Sleep(100 * time.Millisecond)
+	manager.start(t, path)
+	require.Eventually(t, func() bool {
+		registrations, _ := manager.counts()
+		return registrations == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// osqueryd restarts
+	manager.stop()
+	restarted := &fakeManager{registrations: 1}
+	restarted.start(t, path)
+	defer restarted.stop()
+	require.Eventually(t, func() bool {
+		registrations, _ := restarted.counts()
+		return registrations == 2
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// SIGTERM
+	cancel()
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not return after the context was cancelled")
+	}
+	// The extension deregistered before exiting
+	_, deregistrations := restarted.counts()
+	assert.GreaterOrEqual(t, deregistrations, 1)
+}
+
+func TestRunGivesUpWithoutSocket(t *testing.T) {
+	config := testConfig(socketPath(t))
+	config.SocketWait = 300 * time.Millisecond
+
+	start := time.Now()
+	err := Run(context.Background(), config)
+	assert.Error(t, err)
+	assert.Less(t, time.Since(start), 5*time.Second)
+}
@@ -0,0 +1,236 @@
+package utils
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultParsedCacheSize is the memory cap of the parsed file cache
+const DefaultParsedCacheSize = 64 << 20
+
+// ParsedCache keeps the structures parsed from files, e.g. a Preferences
+// file, so an unchanged file isn't read and unmarshalled again by every
+// query. Entries are accounted for with the size of the file they were parsed
+// from and the least recently used ones are evicted past the memory cap.
+type ParsedCache struct {
+	mu       sync.Mutex
+	maxBytes int64
+	ttl      time.Duration
+	size     int64
+	entries  map[parsedKey]*list.Element
+	lru      *list.List
+	hits     int64
+	misses   int64
+}
+
+// parsedKey identifies a file and the parser used on it, the same file can
+// be parsed into different structures by different tables
+type parsedKey struct {
+	kind string
+	path string
+}
+
+// fileVersion tells apart the versions of a file. The device tells apart the
+// same inode number on two filesystems.
+type fileVersion struct {
+	dev   uint64
+	inode uint64
+	mtime int64
+	size  int64
+}
+
+type parsedEntry struct {
+	key     parsedKey
+	version fileVersion
+	value   any
+	cost    int64
+	stored  time.Time
+}
+
+// CacheStats describes the use of a ParsedCache
+type CacheStats struct {
+	Hits    int64
+	Misses  int64
+	Entries int
+	Bytes   int64
+}
+
+// NewParsedCache returns a cache holding up to maxBytes of parsed files.
+// Entries expire after ttl, when it is positive, even if the file didn't
+// change.
+func NewParsedCache(maxBytes int64, ttl time.Duration) *ParsedCache {
+	return &ParsedCache{
+		maxBytes: maxBytes,
+		ttl:      ttl,
+		entries:  map[parsedKey]*list.Element{},
+		lru:      list.New(),
+	}
+}
+
+func (c *ParsedCache) get(key parsedKey, version fileVersion) (any, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[key]
+	if ok {
+		entry := elem.Value.(*parsedEntry)
+		if entry.version == version && (c.ttl <= 0 || time.Since(entry.stored) < c.ttl) {
+			c.lru.MoveToFront(elem)
+			c.hits++
+			return entry.value, true
+		}
+		c.remove(elem)
+	}
+	c.misses++
+	return nil, false
+}
+
+func (c *ParsedCache) add(key parsedKey, version fileVersion, value any, cost int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[key]; ok {
+		c.remove(elem)
+	}
+	if cost > c.maxBytes {
+		return
+	}
+	for c.size+cost > c.maxBytes {
+		c.remove(c.lru.Back())
+	}
+	entry := &parsedEntry{key: key, version: version, value: value, cost: cost, stored: time.Now()}
+	c.entries[key] = c.lru.PushFront(entry)
+	c.size += cost
+}
+
+func (c *ParsedCache) remove(elem *list.Element) {
+	entry := c.lru.Remove(elem).(*parsedEntry)
+	delete(c.entries, entry.key)
+	c.size -= entry.cost
+}
+
+// Stats returns the hits and misses of the cache and its current size
+func (c *ParsedCache) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return CacheStats{Hits: c.hits, Misses: c.misses, Entries: c.lru.Len(), Bytes: c.size}
+}
+
+var (
+	parsedCacheMu sync.RWMutex
+	parsedCache   = NewParsedCache(DefaultParsedCacheSize, 0)
+)
+
+// SetParsedCache replaces the cache shared by the tables. A nil cache
+// disables caching.
+func SetParsedCache(cache *ParsedCache) {
+	parsedCacheMu.Lock()
+	defer parsedCacheMu.Unlock()
+	parsedCache = cache
+}
+
+// GetParsedCache returns the cache shared by the tables, nil when caching is
+// disabled
+func GetParsedCache() *ParsedCache {
+	parsedCacheMu.RLock()
+	defer parsedCacheMu.RUnlock()
+	return parsedCache
+}
+
+type parsedCacheKey struct{}
+
+// WithParsedCache returns a context in which ReadParsed uses cache
+func WithParsedCache(ctx context.Context, cache *ParsedCache) context.Context {
+	return context.WithValue(ctx, parsedCacheKey{}, cache)
+}
+
+// parsedCacheFromContext returns the cache set with WithParsedCache. The
+// shared cache is only used for the host filesystem, the files of another
+// filesystem could have the same path and attributes.
+func parsedCacheFromContext(ctx context.Context) *ParsedCache {
+	if cache, ok := ctx.Value(parsedCacheKey{}).(*ParsedCache); ok {
+		return cache
+	}
+	if FileSystemFromContext(ctx) != OSFileSystem {
+		return nil
+	}
+	return GetParsedCache()
+}
+
+// parseError marks the errors returned by the parse function of ReadParsed
+type parseError struct {
+	err error
+}
+
+func (e *parseError) Error() string { return e.err.Error() }
+func (e *parseError) Unwrap() error { return e.err }
+
+// IsParseError reports whether ReadParsed read the file but failed to parse it
+func IsParseError(err error) bool {
+	var pe *parseError
+	return errors.As(err, &pe)
+}
+
+// ReadParsed returns the structure parse builds from the file at path. It is
+// reused as long as the device, inode, modification time and size of the
+// file don't change, so it must not be modified by the caller. kind names
+// the structure, as the same file can be parsed differently. The file is
+// opened with SafeOpen before the cache is looked up, a cached structure is
+// only returned for a file the context is allowed to read. Read errors are
+// returned as is, parse errors are told apart with IsParseError and aren't
+// cached.
+func ReadParsed[T any](ctx context.Context, kind, path string, parse func([]byte) (T, error)) (T, error) {
+	var zero T
+
+	if err := ctx.Err(); err != nil {
+		return zero, err
+	}
+	file, err := SafeOpen(ctx, path)
+	if err != nil {
+		return zero, err
+	}
+	defer file.Close()
+
+	cache := parsedCacheFromContext(ctx)
+	key := parsedKey{kind: kind, path: path}
+	var version fileVersion
+	if cache != nil {
+		// The version of the opened file, it can't change before it is read
+		info, err := file.Stat()
+		if err != nil {
+			cache = nil
+		} else {
+			dev, inode := fileID(info)
+			version = fileVersion{dev: dev, inode: inode, mtime: info.ModTime().UnixNano(), size: info.Size()}
+			stats := readStatsFromContext(ctx)
+			value, hit := cache.get(key, version)
+			// The type only differs if two parsers use the same kind
+			if parsed, ok := value.(T); hit && ok {
+				if stats != nil {
+					stats.CacheHits.Add(1)
+				}
+				return parsed, nil
+			}
+			if stats != nil {
+				stats.CacheMisses.Add(1)
+			}
+		}
+	}
+
+	data, err := readOpened(ctx, path, file)
+	if err != nil {
+		return zero, err
+	}
+	value, err := parse(data)
+	if err != nil {
+		return zero, &parseError{err: err}
+	}
+	if cache != nil {
+		cache.add(key, version, value, int64(len(data)))
+	}
+	return value, nil
+}
@@ -0,0 +1,149 @@
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func parseJSONMap(data []byte) (map[string]string, error) {
+	var value map[string]string
+	err := json.Unmarshal(data, &value)
+	return value, err
+}
+
+func TestReadParsed(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "Preferences")
+	require.NoError(t, os.WriteFile(path, []byte(`{"a": "1"}`), 0600))
+
+	cache := NewParsedCache(1<<20, 0)
+	stats := &ReadStats{}
+	ctx := WithReadStats(WithParsedCache(context.Background(), cache), stats)
+
+	parses := 0
+	parse := func(data []byte) (map[string]string, error) {
+		parses++
+		return parseJSONMap(data)
+	}
+	for i := 0; i < 3; i++ {
+		value, err := ReadParsed(ctx, "test", path, parse)
+		require.NoError(t, err)
+		assert.Equal(t, map[string]string{"a": "1"}, value)
+	}
+	assert.Equal(t, 1, parses)
+	assert.Equal(t, int64(1), stats.Files.Load())
+	assert.Equal(t, int64(2), stats.CacheHits.Load())
+	assert.Equal(t, int64(1), stats.CacheMisses.Load())
+	assert.Equal(t, CacheStats{Hits: 2, Misses: 1, Entries: 1, Bytes: 10}, cache.Stats())
+
+	// A changed file is parsed again
+	require.NoError(t, os.WriteFile(path, []byte(`{"a": "22"}`), 0600))
+	value, err := ReadParsed(ctx, "test", path, parse)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"a": "22"}, value)
+	assert.Equal(t, 2, parses)
+
+	// Other kinds of structures are cached separately
+	_, err = ReadParsed(ctx, "other", path, parse)
+	require.NoError(t, err)
+	assert.Equal(t, 3, parses)
+	assert.Equal(t, 2, cache.Stats().Entries)
+}
+
+func TestReadParsedErrors(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "Preferences")
+	require.NoError(t, os.WriteFile(path, []byte(`{not json`), 0600))
+	cache := NewParsedCache(1<<20, 0)
+	ctx := WithParsedCache(context.Background(), cache)
+
+	_, err := ReadParsed(ctx, "test", path, parseJSONMap)
+	assert.True(t, IsParseError(err))
+	assert.Equal(t, ErrorClassParseError, ClassifyError(err))
+	assert.Equal(t, 0, cache.Stats().Entries)
+
+	_, err = ReadParsed(ctx, "test", filepath.Join(filepath.Dir(path), "missing"), parseJSONMap)
+	assert.False(t, IsParseError(err))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestParsedCacheEviction(t *testing.T) {
+	cache := NewParsedCache(10, 0)
+	a, b, c := parsedKey{"test", "a"}, parsedKey{"test", "b"}, parsedKey{"test", "c"}
+	cache.add(a, fileVersion{}, "a", 4)
+	cache.add(b, fileVersion{}, "b", 4)
+	// a becomes the most recently used entry, b is evicted for c
+	_, ok := cache.get(a, fileVersion{})
+	assert.True(t, ok)
+	cache.add(c, fileVersion{}, "c", 4)
+
+	_, ok = cache.get(b, fileVersion{})
+	assert.False(t, ok)
+	_, ok = cache.get(a, fileVersion{})
+	assert.True(t, ok)
+	assert.Equal(t, int64(8), cache.Stats().Bytes)
+
+	// Entries larger than the cap aren't stored
+	cache.add(parsedKey{"test", "large"}, fileVersion{}, "large", 11)
+	assert.Equal(t, 2, cache.Stats().Entries)
+
+	// Another version of the file replaces the entry
+	_, ok = cache.get(a, fileVersion{size: 4})
+	assert.False(t, ok)
+	assert.Equal(t, 1, cache.Stats().Entries)
+}
+
+func TestParsedCacheTTL(t *testing.T) {
+	cache := NewParsedCache(10, 20*time.Millisecond)
+	key := parsedKey{"test", "a"}
+	cache.add(key, fileVersion{}, "a", 1)
+	_, ok := cache.get(key, fileVersion{})
+	assert.True(t, ok)
+
+	time.Sleep(30 * time.Millisecond)
+	_, ok = cache.get(key, fileVersion{})
+	assert.False(t, ok)
+}
+
+func TestReadParsedOnFileSystem(t *testing.T) {
+	// The shared cache isn't used for other filesystems, their files can
+	// have the same path and attributes
+	ctx := WithFileSystem(context.Background(), NewFileSystem(fstest.MapFS{"a.json": {Data: []byte(`{"a": "1"}`)}}))
+	assert.Nil(t, parsedCacheFromContext(ctx))
+	value, err := ReadParsed(ctx, "test", filepath.FromSlash("/a.json"), parseJSONMap)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"a": "1"}, value)
+}
+
+func TestReadParsedOtherOwner(t *testing.T) {
+	// A file cached for its owner isn't returned for another owner
+	homes := t.TempDir()
+	bob := UserAccount{Name: "bob", UID: "1001", Home: filepath.Join(homes, "bob")}
+	alice := UserAccount{Name: "alice", UID: "1002", Home: filepath.Join(homes, "alice")}
+	path := filepath.Join(bob.Home, "ext", "manifest.json")
+	require.NoError(t, os.MkdirAll(filepath.Dir(path), 0700))
+	require.NoError(t, os.MkdirAll(alice.Home, 0700))
+	require.NoError(t, os.WriteFile(path, []byte(`{"a": "1"}`), 0600))
+
+	cache := NewParsedCache(1<<20, 0)
+	ctx := WithTableName(WithParsedCache(context.Background(), cache), "test_read_parsed_owner")
+	value, err := ReadParsed(WithOwner(ctx, bob), "test", path, parseJSONMap)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"a": "1"}, value)
+	assert.Equal(t, 1, cache.Stats().Entries)
+
+	before := len(RecentErrors())
+	_, err = ReadParsed(WithOwner(ctx, alice), "test", path, parseJSONMap)
+	assert.ErrorIs(t, err, ErrOutsideHome)
+	assert.Equal(t, int64(0), cache.Stats().Hits)
+	refusals := refusalsSince(before, "test_read_parsed_owner")
+	require.Len(t, refusals, 1)
+	assert.Equal(t, "alice", refusals[0].User)
+	assert.Equal(t, ErrorClassRefused, refusals[0].Class)
+}
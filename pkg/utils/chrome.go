@@ -1,9 +1,15 @@
 package utils
 
 import (
-	"os"
+	"context"
 	"path/filepath"
 	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/osquery/osquery-go/plugin/table"
+	"github.com/pkg/errors"
 )
 
 // Preferences file included in each profile
@@ -21,13 +27,31 @@ var possibleConfigFileNames = []string{
 // ChromeProfilePath represents a Chrome profile path with user and browser type information
 type ChromeProfilePath struct {
 	UserName string
+	UID      string
 	Type     ChromeBrowserType
 	Value    string
+	// Home is the home directory of the user, the files of the profile
+	// must resolve in it
+	Home string
+	// InstallType is how the browser was installed, empty when unknown
+	InstallType ChromeInstallType
+	// Name is the profile name shown by the browser, from Local State
+	Name string
+	// UserDataDir is the directory holding Local State and the profiles
+	UserDataDir string
+}
+
+// Owner returns the account the files of the profile belong to
+func (p ChromeProfilePath) Owner() UserAccount {
+	return UserAccount{Name: p.UserName, UID: p.UID, Home: p.Home}
 }
 
 // ChromeBrowserType represents different types of Chrome-based browsers
 type ChromeBrowserType int
 
+// UnknownChromeBrowser is used when the browser of a profile can't be inferred
+const UnknownChromeBrowser ChromeBrowserType = -1
+
 const (
 	GoogleChrome ChromeBrowserType = iota
 	GoogleChromeBeta
@@ -38,52 +62,117 @@ const (
 	Yandex
 	Edge
 	EdgeBeta
+	EdgeDev
 	Opera
 	Vivaldi
 	Arc
 )
 
+// ChromeInstallType tells how a browser was installed. Sandboxed packages
+// keep the user data directory below their own directory in the home.
+type ChromeInstallType string
+
+const (
+	NativeInstall  ChromeInstallType = "native"
+	SnapInstall    ChromeInstallType = "snap"
+	FlatpakInstall ChromeInstallType = "flatpak"
+)
+
+// ChromeUserDataPath is a candidate location of the user data directory of a
+// browser, relative to the home directory
+type ChromeUserDataPath struct {
+	Suffix      string
+	InstallType ChromeInstallType
+}
+
 // WindowsPathList maps browser types to their Windows installation paths
-var WindowsPathList = map[ChromeBrowserType]string{
-	GoogleChrome:       "AppData\\Local\\Google\\Chrome\\User Data",
-	GoogleChromeBeta:   "AppData\\Local\\Google\\Chrome Beta\\User Data",
-	GoogleChromeDev:    "AppData\\Local\\Google\\Chrome Dev\\User Data",
-	GoogleChromeCanary: "AppData\\Local\\Google\\Chrome SxS\\User Data",
-	Brave:              "AppData\\Roaming\\brave",
-	Chromium:           "AppData\\Local\\Chromium",
-	Yandex:             "AppData\\Local\\Yandex\\YandexBrowser\\User Data",
-	Edge:               "AppData\\Local\\Microsoft\\Edge\\User Data",
-	EdgeBeta:           "AppData\\Local\\Microsoft\\Edge Beta\\User Data",
-	Opera:              "AppData\\Roaming\\Opera Software\\Opera Stable",
-	Vivaldi:            "AppData\\Local\\Vivaldi\\User Data",
+var WindowsPathList = map[ChromeBrowserType][]ChromeUserDataPath{
+	GoogleChrome:       {{"AppData\\Local\\Google\\Chrome\\User Data", NativeInstall}},
+	GoogleChromeBeta:   {{"AppData\\Local\\Google\\Chrome Beta\\User Data", NativeInstall}},
+	GoogleChromeDev:    {{"AppData\\Local\\Google\\Chrome Dev\\User Data", NativeInstall}},
+	GoogleChromeCanary: {{"AppData\\Local\\Google\\Chrome SxS\\User Data", NativeInstall}},
+	Brave:              {{"AppData\\Roaming\\brave", NativeInstall}},
+	Chromium:           {{"AppData\\Local\\Chromium", NativeInstall}},
+	Yandex:             {{"AppData\\Local\\Yandex\\YandexBrowser\\User Data", NativeInstall}},
+	Edge:               {{"AppData\\Local\\Microsoft\\Edge\\User Data", NativeInstall}},
+	EdgeBeta:           {{"AppData\\Local\\Microsoft\\Edge Beta\\User Data", NativeInstall}},
+	EdgeDev:            {{"AppData\\Local\\Microsoft\\Edge Dev\\User Data", NativeInstall}},
+	Opera:              {{"AppData\\Roaming\\Opera Software\\Opera Stable", NativeInstall}},
+	Vivaldi:            {{"AppData\\Local\\Vivaldi\\User Data", NativeInstall}},
 }
 
 // MacOSPathList maps browser types to their macOS installation paths
-var MacOSPathList = map[ChromeBrowserType]string{
-	GoogleChrome:       "Library/Application Support/Google/Chrome",
-	GoogleChromeBeta:   "Library/Application Support/Google/Chrome Beta",
-	GoogleChromeDev:    "Library/Application Support/Google/Chrome Dev",
-	GoogleChromeCanary: "Library/Application Support/Google/Chrome Canary",
-	Brave:              "Library/Application Support/BraveSoftware/Brave-Browser",
-	Chromium:           "Library/Application Support/Chromium",
-	Yandex:             "Library/Application Support/Yandex/YandexBrowser",
-	Edge:               "Library/Application Support/Microsoft Edge",
-	EdgeBeta:           "Library/Application Support/Microsoft Edge Beta",
-	Opera:              "Library/Application Support/com.operasoftware.Opera",
-	Vivaldi:            "Library/Application Support/Vivaldi",
-	Arc:                "Library/Application Support/Arc/User Data",
-}
-
-// LinuxPathList maps browser types to their Linux installation paths
-var LinuxPathList = map[ChromeBrowserType]string{
-	GoogleChrome:     ".config/google-chrome",
-	GoogleChromeBeta: ".config/google-chrome-beta",
-	GoogleChromeDev:  ".config/google-chrome-unstable",
-	Brave:            ".config/BraveSoftware/Brave-Browser",
-	Chromium:         ".config/chromium",
-	Yandex:           ".config/yandex-browser-beta",
-	Opera:            ".config/opera",
-	Vivaldi:          ".config/vivaldi",
+var MacOSPathList = map[ChromeBrowserType][]ChromeUserDataPath{
+	GoogleChrome:       {{"Library/Application Support/Google/Chrome", NativeInstall}},
+	GoogleChromeBeta:   {{"Library/Application Support/Google/Chrome Beta", NativeInstall}},
+	GoogleChromeDev:    {{"Library/Application Support/Google/Chrome Dev", NativeInstall}},
+	GoogleChromeCanary: {{"Library/Application Support/Google/Chrome Canary", NativeInstall}},
+	Brave:              {{"Library/Application Support/BraveSoftware/Brave-Browser", NativeInstall}},
+	Chromium:           {{"Library/Application Support/Chromium", NativeInstall}},
+	Yandex:             {{"Library/Application Support/Yandex/YandexBrowser", NativeInstall}},
+	Edge:               {{"Library/Application Support/Microsoft Edge", NativeInstall}},
+	EdgeBeta:           {{"Library/Application Support/Microsoft Edge Beta", NativeInstall}},
+	EdgeDev:            {{"Library/Application Support/Microsoft Edge Dev", NativeInstall}},
+	Opera:              {{"Library/Application Support/com.operasoftware.Opera", NativeInstall}},
+	Vivaldi:            {{"Library/Application Support/Vivaldi", NativeInstall}},
+	Arc:                {{"Library/Application Support/Arc/User Data", NativeInstall}},
+}
+
+// LinuxPathList maps browser types to their Linux installation paths. Snap
+// packages keep their data in ~/snap/<name>, the current revision being
+// linked as current, and Flatpak ones in ~/.var/app/<application id>.
+var LinuxPathList = map[ChromeBrowserType][]ChromeUserDataPath{
+	GoogleChrome: {
+		{".config/google-chrome", NativeInstall},
+		{".var/app/com.google.Chrome/config/google-chrome", FlatpakInstall},
+	},
+	GoogleChromeBeta: {
+		{".config/google-chrome-beta", NativeInstall},
+	},
+	GoogleChromeDev: {
+		{".config/google-chrome-unstable", NativeInstall},
+		{".var/app/com.google.ChromeDev/config/google-chrome-unstable", FlatpakInstall},
+	},
+	GoogleChromeCanary: {
+		{".config/google-chrome-canary", NativeInstall},
+	},
+	Brave: {
+		{".config/BraveSoftware/Brave-Browser", NativeInstall},
+		{"snap/brave/current/.config/BraveSoftware/Brave-Browser", SnapInstall},
+		{".var/app/com.brave.Browser/config/BraveSoftware/Brave-Browser", FlatpakInstall},
+	},
+	Chromium: {
+		{".config/chromium", NativeInstall},
+		{".config/chromium-browser", NativeInstall},
+		{"snap/chromium/common/chromium", SnapInstall},
+		{".var/app/org.chromium.Chromium/config/chromium", FlatpakInstall},
+	},
+	Yandex: {
+		{".config/yandex-browser", NativeInstall},
+		{".config/yandex-browser-beta", NativeInstall},
+		{".var/app/ru.yandex.Browser/config/yandex-browser", FlatpakInstall},
+	},
+	Edge: {
+		{".config/microsoft-edge", NativeInstall},
+		{".var/app/com.microsoft.Edge/config/microsoft-edge", FlatpakInstall},
+	},
+	EdgeBeta: {
+		{".config/microsoft-edge-beta", NativeInstall},
+	},
+	EdgeDev: {
+		{".config/microsoft-edge-dev", NativeInstall},
+		{".var/app/com.microsoft.EdgeDev/config/microsoft-edge-dev", FlatpakInstall},
+	},
+	Opera: {
+		{".config/opera", NativeInstall},
+		{"snap/opera/current/.config/opera", SnapInstall},
+		{".var/app/com.opera.Opera/config/opera", FlatpakInstall},
+	},
+	Vivaldi: {
+		{".config/vivaldi", NativeInstall},
+		{"snap/vivaldi/current/.config/vivaldi", SnapInstall},
+		{".var/app/com.vivaldi.Vivaldi/config/vivaldi", FlatpakInstall},
+	},
 }
 
 // ChromeBrowserTypeToString maps browser types to their string representations
@@ -98,6 +187,7 @@ var ChromeBrowserTypeToString = map[ChromeBrowserType]string{
 	Opera:              "opera",
 	Edge:               "edge",
 	EdgeBeta:           "edge_beta",
+	EdgeDev:            "edge_dev",
 	Vivaldi:            "vivaldi",
 	Arc:                "arc",
 }
@@ -111,8 +201,9 @@ func GetChromeBrowserName(t ChromeBrowserType) string {
 	return name
 }
 
-// GetChromePathSuffixMap returns the appropriate path list based on the operating system
-func GetChromePathSuffixMap() map[ChromeBrowserType]string {
+// GetChromePathSuffixMap returns the candidate user data directories of each
+// browser on the operating system
+func GetChromePathSuffixMap() map[ChromeBrowserType][]ChromeUserDataPath {
 	switch runtime.GOOS {
 	case "windows":
 		return WindowsPathList
@@ -125,12 +216,12 @@ func GetChromePathSuffixMap() map[ChromeBrowserType]string {
 
 // isValidChromeProfile returns true if the given path contains either the Preferences
 // or the Secure Preferences file
-func isValidChromeProfile(path string) bool {
+func isValidChromeProfile(fsys FileSystem, path string) bool {
 	for _, configFileName := range possibleConfigFileNames {
 		preferencesFilePath := filepath.Join(path, configFileName)
 
-		if _, err := os.Stat(preferencesFilePath); err == nil {
-			// If os.Stat returns no error, the file exists and is accessible
+		if _, err := fsys.Stat(preferencesFilePath); err == nil {
+			// If Stat returns no error, the file exists and is accessible
 			return true
 		}
 	}
@@ -139,8 +230,8 @@ func isValidChromeProfile(path string) bool {
 }
 
 // listDirectoriesInDirectory returns a slice of subdirectories within the given path.
-func listDirectoriesInDirectory(path string) ([]string, error) {
-	entries, err := os.ReadDir(path)
+func listDirectoriesInDirectory(fsys FileSystem, path string) ([]string, error) {
+	entries, err := fsys.ReadDir(path)
 	if err != nil {
 		return nil, err
 	}
@@ -154,77 +245,286 @@ func listDirectoriesInDirectory(path string) ([]string, error) {
 	return dirs, nil
 }
 
-// GetChromeProfilePathList attempts to discover valid Chrome profiles
-// based on user information and known Chrome installation paths.
-func GetChromeProfilePathList() ([]ChromeProfilePath, error) {
-	// Get User directories
-	userInfoList := map[string]string{}
-	for _, possibleHome := range HomeDirLocations[runtime.GOOS] {
-		userDirs, err := os.ReadDir(possibleHome)
-		if err != nil {
-			return nil, err
-		}
+// chromeProfileFilter restricts profile discovery to the given users,
+// browsers and profile directory names. Empty sets match everything.
+type chromeProfileFilter struct {
+	root         string
+	usernames    map[string]bool
+	browserTypes map[string]bool
+	profiles     map[string]bool
+	installTypes map[string]bool
+}
 
-		for _, userDir := range userDirs {
-			if userDir.IsDir() {
-				userInfoList[userDir.Name()] = filepath.Join(possibleHome, userDir.Name())
-			}
-		}
+type ChromeProfileOpt func(*chromeProfileFilter)
+
+// WithRootDir overrides the directory prefixed to the home directory roots,
+// which defaults to the one set with SetRootDir
+func WithRootDir(root string) ChromeProfileOpt {
+	return func(f *chromeProfileFilter) {
+		f.root = root
+	}
+}
+
+// WithUsernames restricts discovery to the home directories of these users
+func WithUsernames(usernames ...string) ChromeProfileOpt {
+	return func(f *chromeProfileFilter) {
+		f.usernames = toSet(f.usernames, usernames)
+	}
+}
+
+// WithBrowserTypes restricts discovery to these browsers, using the names
+// returned by GetChromeBrowserName
+func WithBrowserTypes(browserTypes ...string) ChromeProfileOpt {
+	return func(f *chromeProfileFilter) {
+		f.browserTypes = toSet(f.browserTypes, browserTypes)
+	}
+}
+
+// WithProfiles restricts discovery to these profile directory names
+func WithProfiles(profiles ...string) ChromeProfileOpt {
+	return func(f *chromeProfileFilter) {
+		f.profiles = toSet(f.profiles, profiles)
+	}
+}
+
+// WithInstallTypes restricts discovery to browsers installed natively, as a
+// Snap or as a Flatpak
+func WithInstallTypes(installTypes ...string) ChromeProfileOpt {
+	return func(f *chromeProfileFilter) {
+		f.installTypes = toSet(f.installTypes, installTypes)
+	}
+}
+
+func toSet(set map[string]bool, values []string) map[string]bool {
+	if set == nil {
+		set = map[string]bool{}
+	}
+	for _, v := range values {
+		set[v] = true
+	}
+	return set
+}
+
+func (f *chromeProfileFilter) matchBrowser(browserType ChromeBrowserType) bool {
+	return len(f.browserTypes) == 0 || f.browserTypes[GetChromeBrowserName(browserType)]
+}
+
+func (f *chromeProfileFilter) matchInstallType(installType ChromeInstallType) bool {
+	return len(f.installTypes) == 0 || f.installTypes[string(installType)]
+}
+
+func (f *chromeProfileFilter) matchProfile(path string) bool {
+	return len(f.profiles) == 0 || f.profiles[filepath.Base(path)]
+}
+
+// ChromeProfileOptsFromQueryContext pushes the equality and IN constraints
+// on the user, browser_type, install_type and profile columns down into
+// profile discovery.
+func ChromeProfileOptsFromQueryContext(queryContext table.QueryContext) []ChromeProfileOpt {
+	var opts []ChromeProfileOpt
+	if usernames := GetConstraintValues(queryContext, "user"); len(usernames) > 0 {
+		opts = append(opts, WithUsernames(usernames...))
+	}
+	if browserTypes := GetConstraintValues(queryContext, "browser_type"); len(browserTypes) > 0 {
+		opts = append(opts, WithBrowserTypes(browserTypes...))
+	}
+	if installTypes := GetConstraintValues(queryContext, "install_type"); len(installTypes) > 0 {
+		opts = append(opts, WithInstallTypes(installTypes...))
 	}
+	if profiles := GetConstraintValues(queryContext, "profile"); len(profiles) > 0 {
+		opts = append(opts, WithProfiles(profiles...))
+	}
+	return opts
+}
+
+// listUserHomes returns the accounts whose home directory is searched for
+// profiles. When the filter has user names only those accounts are returned
+// and the homes of the others aren't looked at.
+func listUserHomes(ctx context.Context, filter *chromeProfileFilter) []UserAccount {
+	return listUserAccounts(ctx, filter.root, filter.usernames)
+}
 
+// GetChromeProfilePathList attempts to discover valid Chrome profiles
+// based on user information and known Chrome installation paths, on the
+// filesystem of the context.
+func GetChromeProfilePathList(ctx context.Context, opts ...ChromeProfileOpt) ([]ChromeProfilePath, error) {
+	filter := &chromeProfileFilter{root: GetRootDir()}
+	for _, opt := range opts {
+		opt(filter)
+	}
+
+	fsys := FileSystemFromContext(ctx)
 	var output []ChromeProfilePath
 
-	for userName, userPath := range userInfoList {
+	// An unreadable home directory only skips the profiles of that user
+	for _, account := range listUserHomes(ctx, filter) {
+		// The profiles found so far are scanned until the deadline
+		if ctx.Err() != nil {
+			break
+		}
+		userPath := account.Home
 		// Prepare a ChromeProfilePath struct, fill the UID for each user.
 		chromeProfile := ChromeProfilePath{
-			UserName: userName,
+			UserName: account.Name,
+			UID:      account.UID,
+			Home:     account.Home,
 		}
 
-		pathSuffixMap := GetChromePathSuffixMap()
+		// Distribution packages sometimes link one location to another,
+		// e.g. .config/chromium-browser to .config/chromium
+		seen := map[string]bool{}
+
+		for browserType, userDataPaths := range GetChromePathSuffixMap() {
+			if !filter.matchBrowser(browserType) {
+				continue
+			}
 
-		for browserType, pathSuffix := range pathSuffixMap {
 			// Set the browser type in the profile.
 			chromeProfile.Type = browserType
 
-			// Construct the path to the user's Chrome directory.
-			path := filepath.Join(userPath, pathSuffix)
+			for _, userDataPath := range userDataPaths {
+				if !filter.matchInstallType(userDataPath.InstallType) {
+					continue
+				}
+				// Construct the path to the user's Chrome directory.
+				path := filepath.Join(userPath, userDataPath.Suffix)
 
-			// Attempt to resolve symlinks.
-			absoluteChromePath, err := filepath.EvalSymlinks(path)
-			if err != nil {
-				// If an error occurs, just use the original path.
-				absoluteChromePath = path
-			}
+				// Attempt to resolve symlinks.
+				absoluteChromePath, err := evalSymlinks(fsys, filter.root, path)
+				if errors.Is(err, errOutsideRoot) {
+					continue
+				}
+				if err != nil {
+					// If an error occurs, just use the original path.
+					absoluteChromePath = path
+				}
+				if seen[absoluteChromePath] {
+					continue
+				}
+				seen[absoluteChromePath] = true
 
-			// Check if this directory itself is a valid Chrome profile.
-			if isValidChromeProfile(absoluteChromePath) {
-				chromeProfile.Value = absoluteChromePath
-				output = append(output, chromeProfile)
-				continue
+				chromeProfile.InstallType = userDataPath.InstallType
+				output = append(output, profilesInUserDataDir(ctx, filter, chromeProfile, absoluteChromePath)...)
 			}
+		}
+	}
+
+	return output, nil
+}
 
-			// Otherwise, attempt to find subdirectories that may be valid profiles.
-			subfolders, err := listDirectoriesInDirectory(absoluteChromePath)
-			if err != nil {
-				// If there's an error listing directories, skip this folder.
+// profilesInUserDataDir returns the profiles of a user data directory, the
+// directory itself when it is a profile. chromeProfile holds the user and
+// browser of the directory.
+func profilesInUserDataDir(ctx context.Context, filter *chromeProfileFilter, chromeProfile ChromeProfilePath, userDataDir string) []ChromeProfilePath {
+	fsys := FileSystemFromContext(ctx)
+	chromeProfile.UserDataDir = userDataDir
+
+	// Check if this directory itself is a valid Chrome profile.
+	if isValidChromeProfile(fsys, userDataDir) {
+		if !filter.matchProfile(userDataDir) {
+			return nil
+		}
+		chromeProfile.Value = userDataDir
+		return []ChromeProfilePath{chromeProfile}
+	}
+
+	// Local State lists the profiles of the user data directory with their
+	// names. Otherwise, attempt to find subdirectories that may be valid
+	// profiles.
+	localState, err := ReadChromeLocalState(ctx, userDataDir)
+	infoCache := localState.Profile.InfoCache
+	var subfolders []string
+	if err == nil && len(infoCache) > 0 {
+		for dir := range infoCache {
+			// The keys come from a file written by the user
+			if !isProfileDirName(dir) {
 				continue
 			}
+			subfolders = append(subfolders, filepath.Join(userDataDir, dir))
+		}
+		sort.Strings(subfolders)
+	} else if subfolders, err = listDirectoriesInDirectory(fsys, userDataDir); err != nil {
+		// If there's an error listing directories, skip this folder.
+		return nil
+	}
 
-			// Check each subfolder for a valid Chrome profile.
-			for _, subfolder := range subfolders {
-				absSubfolder, err := filepath.EvalSymlinks(subfolder)
-				if err != nil {
-					absSubfolder = subfolder
-				}
+	// Check each subfolder for a valid Chrome profile.
+	var output []ChromeProfilePath
+	for _, subfolder := range subfolders {
+		if nonProfileDirs[filepath.Base(subfolder)] {
+			continue
+		}
+		absSubfolder, err := evalSymlinks(fsys, filter.root, subfolder)
+		if errors.Is(err, errOutsideRoot) {
+			continue
+		}
+		if err != nil {
+			absSubfolder = subfolder
+		}
 
-				if isValidChromeProfile(absSubfolder) {
-					chromeProfile.Value = absSubfolder
-					output = append(output, chromeProfile)
-					continue
-				}
+		if !filter.matchProfile(absSubfolder) {
+			continue
+		}
+
+		if isValidChromeProfile(fsys, absSubfolder) {
+			chromeProfile.Value = absSubfolder
+			chromeProfile.Name = infoCache[filepath.Base(subfolder)].Name
+			output = append(output, chromeProfile)
+		}
+	}
+	return output
+}
+
+// ChromeProfileFromPath builds the ChromeProfilePath of a file passed
+// explicitly through a path constraint, e.g. a Preferences file copied off a
+// machine. The user and browser type are inferred when the file lives in a
+// known location, otherwise they are left empty.
+func ChromeProfileFromPath(ctx context.Context, path string) ChromeProfilePath {
+	profile := ChromeProfilePath{
+		Type:  UnknownChromeBrowser,
+		Value: filepath.Dir(path),
+	}
+	// Newer Chrome versions keep network files in a Network subdirectory
+	if filepath.Base(profile.Value) == "Network" {
+		profile.Value = filepath.Dir(profile.Value)
+	}
+	profile.UserDataDir = filepath.Dir(profile.Value)
+	profile.Name = profileDisplayName(ctx, profile.Value)
+
+	account, userRelPath, found := UserFromPath(ctx, path)
+	if !found {
+		return profile
+	}
+	profile.UserName = account.Name
+	profile.UID = account.UID
+	profile.Home = account.Home
+
+	for browserType, userDataPaths := range GetChromePathSuffixMap() {
+		for _, userDataPath := range userDataPaths {
+			if strings.HasPrefix(userRelPath, filepath.Clean(userDataPath.Suffix)+string(filepath.Separator)) {
+				profile.Type = browserType
+				profile.InstallType = userDataPath.InstallType
+				return profile
 			}
 		}
 	}
+	return profile
+}
 
-	return output, nil
+// chromeEpochOffset is the number of seconds between the Windows epoch
+// (1601-01-01) used by Chrome timestamps and the unix epoch.
+const chromeEpochOffset = 11644473600
+
+// ChromeTimeToUnix converts a Chrome timestamp (microseconds since 1601-01-01)
+// into unix seconds. It returns 0 for empty or invalid values.
+func ChromeTimeToUnix(value string) int64 {
+	chromeTime, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || chromeTime <= 0 {
+		return 0
+	}
+	unixTime := chromeTime/1000000 - chromeEpochOffset
+	if unixTime < 0 {
+		return 0
+	}
+	return unixTime
 }
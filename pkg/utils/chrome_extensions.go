@@ -0,0 +1,297 @@
+package utils
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"io/fs"
+	"log"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ChromeExtensionSettings represents a single entry of extensions.settings
+// in the Preferences and Secure Preferences files.
+type ChromeExtensionSettings struct {
+	DisableReasons json.RawMessage          `json:"disable_reasons,omitempty"`
+	FromWebstore   bool                     `json:"from_webstore"`
+	InstallTime    string                   `json:"install_time,omitempty"`
+	Location       int                      `json:"location"`
+	Path           string                   `json:"path,omitempty"`
+	State          *int                     `json:"state,omitempty"`
+	Manifest       *ChromeExtensionManifest `json:"manifest,omitempty"`
+}
+
+// ChromeExtensionManifest represents the fields we use from an extension's
+// manifest.json.
+type ChromeExtensionManifest struct {
+	Name            string        `json:"name"`
+	Version         string        `json:"version"`
+	ManifestVersion int           `json:"manifest_version"`
+	DefaultLocale   string        `json:"default_locale"`
+	UpdateURL       string        `json:"update_url"`
+	Permissions     []interface{} `json:"permissions"`
+	HostPermissions []string      `json:"host_permissions"`
+}
+
+// ChromeExtension is an extension registered in a profile, joined with its
+// manifest on disk.
+type ChromeExtension struct {
+	ID       string
+	Name     string
+	Path     string
+	Settings *ChromeExtensionSettings
+	Manifest *ChromeExtensionManifest
+}
+
+type extensionPreferences struct {
+	Extensions struct {
+		Settings map[string]json.RawMessage `json:"settings"`
+	} `json:"extensions"`
+}
+
+type localeMessage struct {
+	Message string `json:"message"`
+}
+
+// chromeExtensionLocations maps the Chromium ManifestLocation enum to a readable name
+var chromeExtensionLocations = map[int]string{
+	1:  "internal",
+	2:  "external_pref",
+	3:  "external_registry",
+	4:  "unpacked",
+	5:  "component",
+	6:  "external_pref_download",
+	7:  "external_policy_download",
+	8:  "command_line",
+	9:  "external_policy",
+	10: "external_component",
+}
+
+var localizedMessage = regexp.MustCompile(`^__MSG_(.+)__$`)
+
+// GetChromeExtensionLocation returns the string representation of an
+// extension install location
+func GetChromeExtensionLocation(location int) string {
+	return chromeExtensionLocations[location]
+}
+
+// readExtensionSettings merges extensions.settings from the Preferences and
+// Secure Preferences files. Values from Secure Preferences take precedence.
+func readExtensionSettings(ctx context.Context, profilePath string) (map[string]*ChromeExtensionSettings, error) {
+	settings := map[string]*ChromeExtensionSettings{}
+	found := false
+
+	for _, fileName := range []string{ProfilePreferencesFile, SecureProfilePreferencesFile} {
+		data, err := ReadParsed(ctx, "chrome_extension_preferences", filepath.Join(profilePath, fileName),
+			func(fileContent []byte) (extensionPreferences, error) {
+				var data extensionPreferences
+				err := json.Unmarshal(fileContent, &data)
+				return data, err
+			})
+		if IsParseError(err) {
+			return nil, errors.Wrapf(err, "unmarshalling %s file", fileName)
+		}
+		if err != nil {
+			continue
+		}
+		found = true
+
+		for id, raw := range data.Extensions.Settings {
+			entry, ok := settings[id]
+			if !ok {
+				entry = &ChromeExtensionSettings{}
+				settings[id] = entry
+			}
+			// Unmarshalling on top of the existing entry keeps the fields
+			// that are only present in the first file.
+			if err := json.Unmarshal(raw, entry); err != nil {
+				log.Printf("Error parsing settings for extension %s: %s", id, err)
+				continue
+			}
+		}
+	}
+
+	if !found {
+		return nil, errors.Wrap(fs.ErrNotExist, "no preferences file found")
+	}
+	return settings, nil
+}
+
+// extensionDir returns the on-disk directory of an extension.
+func extensionDir(ctx context.Context, profilePath, id string, settings *ChromeExtensionSettings) string {
+	if settings != nil && settings.Path != "" {
+		// Unpacked and component extensions are referenced by absolute path
+		if filepath.IsAbs(settings.Path) {
+			return RootPath(settings.Path)
+		}
+		return filepath.Join(profilePath, "Extensions", settings.Path)
+	}
+
+	// Fallback to the latest version directory available on disk.
+	versions, err := FileSystemFromContext(ctx).Glob(filepath.Join(profilePath, "Extensions", id, "*"))
+	if err != nil || len(versions) == 0 {
+		return ""
+	}
+	sort.Slice(versions, func(i, j int) bool {
+		return compareVersionDirs(filepath.Base(versions[i]), filepath.Base(versions[j])) < 0
+	})
+	return versions[len(versions)-1]
+}
+
+// compareVersionDirs orders the version directories of an extension, named
+// after the version and an install counter, e.g. 10.0.1_0. The dot separated
+// components are compared as numbers so 10.0 comes after 9.0.
+func compareVersionDirs(a, b string) int {
+	aVersion, aCounter, _ := strings.Cut(a, "_")
+	bVersion, bCounter, _ := strings.Cut(b, "_")
+	if c := compareVersions(aVersion, bVersion); c != 0 {
+		return c
+	}
+	return compareVersions(aCounter, bCounter)
+}
+
+// compareVersions compares dot separated versions component by component,
+// numerically when both components are numbers
+func compareVersions(a, b string) int {
+	aParts := strings.Split(a, ".")
+	bParts := strings.Split(b, ".")
+	for i := 0; i < len(aParts) && i < len(bParts); i++ {
+		aNum, aErr := strconv.ParseUint(aParts[i], 10, 64)
+		bNum, bErr := strconv.ParseUint(bParts[i], 10, 64)
+		c := strings.Compare(aParts[i], bParts[i])
+		if aErr == nil && bErr == nil {
+			c = cmp.Compare(aNum, bNum)
+		}
+		if c != 0 {
+			return c
+		}
+	}
+	return cmp.Compare(len(aParts), len(bParts))
+}
+
+// readManifest reads manifest.json from the extension directory.
+func readManifest(ctx context.Context, extDir string) (*ChromeExtensionManifest, error) {
+	manifest, err := ReadParsed(ctx, "chrome_extension_manifest", filepath.Join(extDir, "manifest.json"),
+		func(fileContent []byte) (ChromeExtensionManifest, error) {
+			var manifest ChromeExtensionManifest
+			err := json.Unmarshal(fileContent, &manifest)
+			return manifest, err
+		})
+	if IsParseError(err) {
+		return nil, errors.Wrap(err, "unmarshalling manifest file")
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "reading manifest file")
+	}
+	return &manifest, nil
+}
+
+// resolveLocalizedName replaces a __MSG_*__ placeholder with the message
+// found in the extension's _locales directory.
+func resolveLocalizedName(ctx context.Context, extDir string, manifest *ChromeExtensionManifest) string {
+	match := localizedMessage.FindStringSubmatch(manifest.Name)
+	if match == nil || extDir == "" {
+		return manifest.Name
+	}
+	key := strings.ToLower(match[1])
+
+	locales := []string{"en", "en_US"}
+	if manifest.DefaultLocale != "" {
+		locales = append([]string{manifest.DefaultLocale}, locales...)
+	}
+
+	for _, locale := range locales {
+		messages, err := ReadParsed(ctx, "chrome_extension_messages", filepath.Join(extDir, "_locales", locale, "messages.json"),
+			func(fileContent []byte) (map[string]localeMessage, error) {
+				var messages map[string]localeMessage
+				err := json.Unmarshal(fileContent, &messages)
+				return messages, err
+			})
+		if err != nil {
+			continue
+		}
+		// Message keys are case insensitive
+		for k, v := range messages {
+			if strings.ToLower(k) == key && v.Message != "" {
+				return v.Message
+			}
+		}
+	}
+	return manifest.Name
+}
+
+// newChromeExtension joins the settings of an extension with its manifest.
+func newChromeExtension(ctx context.Context, profilePath, id string, settings *ChromeExtensionSettings) ChromeExtension {
+	extDir := extensionDir(ctx, profilePath, id, settings)
+
+	// Some extensions (e.g. component) embed the manifest in the preferences
+	manifest := &ChromeExtensionManifest{}
+	if settings != nil && settings.Manifest != nil {
+		manifest = settings.Manifest
+	}
+	name := manifest.Name
+
+	readable := extDir != ""
+	if readable && settings != nil && filepath.IsAbs(settings.Path) {
+		ctx, readable = outsideExtensionContext(ctx, extDir)
+	}
+	if readable {
+		if onDisk, err := readManifest(ctx, extDir); err == nil {
+			manifest = onDisk
+		}
+		name = resolveLocalizedName(ctx, extDir, manifest)
+	}
+
+	return ChromeExtension{
+		ID:       id,
+		Name:     name,
+		Path:     extDir,
+		Settings: settings,
+		Manifest: manifest,
+	}
+}
+
+// outsideExtensionContext returns the context to read an extension the
+// browser references by absolute path. Those are usually outside of the
+// user's home, e.g. /opt/google/chrome/resources/pdf, and are read when the
+// directory is owned by the user or root. It returns false when the
+// directory can't be read.
+func outsideExtensionContext(ctx context.Context, extDir string) (context.Context, bool) {
+	owner, _ := ownerFromContext(ctx)
+	err := checkOwner(ctx, owner, extDir, true)
+	if errors.Is(err, ErrNotOwned) {
+		refuse(ctx, "open", extDir, ErrNotOwned)
+	}
+	if err != nil {
+		return ctx, false
+	}
+	return withExtensionDir(ctx, extDir), true
+}
+
+// GetChromeExtensions returns the extensions registered in the Preferences
+// and Secure Preferences files of a profile, keyed by extension ID.
+func GetChromeExtensions(ctx context.Context, profilePath string) (map[string]ChromeExtension, error) {
+	settings, err := readExtensionSettings(ctx, profilePath)
+	if err != nil {
+		return nil, errors.Wrap(err, "reading extension settings")
+	}
+
+	extensions := make(map[string]ChromeExtension, len(settings))
+	for id, setting := range settings {
+		extensions[id] = newChromeExtension(ctx, profilePath, id, setting)
+	}
+	return extensions, nil
+}
+
+// GetLeftoverChromeExtension looks for the files of an extension that is no
+// longer registered in the profile. It returns false if nothing is left.
+func GetLeftoverChromeExtension(ctx context.Context, profilePath, id string) (ChromeExtension, bool) {
+	extension := newChromeExtension(ctx, profilePath, id, nil)
+	return extension, extension.Path != ""
+}
@@ -0,0 +1,29 @@
+package utils
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompareVersionDirs(t *testing.T) {
+	assert.Negative(t, compareVersionDirs("9.0_0", "10.0_0"))
+	assert.Negative(t, compareVersionDirs("1.2.9_0", "1.2.10_0"))
+	assert.Negative(t, compareVersionDirs("1.0_5", "1.0.1_0"))
+	assert.Negative(t, compareVersionDirs("1.0_0", "1.0_1"))
+	assert.Positive(t, compareVersionDirs("2.0_0", "1.99.99_3"))
+	assert.Zero(t, compareVersionDirs("8.10.36_0", "8.10.36_0"))
+}
+
+func TestExtensionDirLatestVersion(t *testing.T) {
+	ctx := WithFileSystem(context.Background(), NewFileSystem(fstest.MapFS{
+		"profile/Extensions/abc/9.0_0/manifest.json":  {Data: []byte("{}")},
+		"profile/Extensions/abc/10.0_0/manifest.json": {Data: []byte("{}")},
+		"profile/Extensions/abc/9.5_0/manifest.json":  {Data: []byte("{}")},
+	}))
+	profilePath := filepath.FromSlash("/profile")
+	assert.Equal(t, filepath.Join(profilePath, "Extensions", "abc", "10.0_0"), extensionDir(ctx, profilePath, "abc", nil))
+}
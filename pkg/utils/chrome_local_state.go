@@ -0,0 +1,90 @@
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"strings"
+)
+
+// LocalStateFile is the browser wide state kept in the user data directory,
+// next to the profile directories
+const LocalStateFile = "Local State"
+
+// LastVersionFile holds the version of the browser that last used the user
+// data directory
+const LastVersionFile = "Last Version"
+
+// noHostedDomain is the hosted_domain of accounts outside of a Google
+// Workspace organization
+const noHostedDomain = "NO_HOSTED_DOMAIN"
+
+// nonProfileDirs are directories with a Preferences file that aren't user
+// profiles
+var nonProfileDirs = map[string]bool{
+	"System Profile": true,
+	"Guest Profile":  true,
+}
+
+// isProfileDirName reports whether a key of the info_cache names a directory
+// of the user data directory. Local State is written by the user, a key like
+// ../../bob/.config/google-chrome/Default must not lead to another directory.
+func isProfileDirName(name string) bool {
+	return name != "" && name != "." && name != ".." && !strings.ContainsAny(name, `/\`) && filepath.Base(name) == name
+}
+
+// ChromeProfileInfo is the entry of a profile in the profile.info_cache of
+// Local State
+type ChromeProfileInfo struct {
+	Name         string  `json:"name"`
+	GaiaName     string  `json:"gaia_name"`
+	UserName     string  `json:"user_name"`
+	HostedDomain string  `json:"hosted_domain"`
+	AvatarIcon   string  `json:"avatar_icon"`
+	ActiveTime   float64 `json:"active_time"`
+	IsEphemeral  bool    `json:"is_ephemeral"`
+}
+
+// IsManaged reports whether the profile is signed in with an account of a
+// Google Workspace organization
+func (i ChromeProfileInfo) IsManaged() bool {
+	return i.HostedDomain != "" && i.HostedDomain != noHostedDomain
+}
+
+// ChromeLocalState is the part of Local State describing the profiles
+type ChromeLocalState struct {
+	Profile struct {
+		InfoCache map[string]ChromeProfileInfo `json:"info_cache"`
+		LastUsed  string                       `json:"last_used"`
+	} `json:"profile"`
+}
+
+// ReadChromeLocalState parses the Local State file of a user data directory
+func ReadChromeLocalState(ctx context.Context, userDataDir string) (ChromeLocalState, error) {
+	return ReadParsed(ctx, "chrome_local_state", filepath.Join(userDataDir, LocalStateFile),
+		func(data []byte) (ChromeLocalState, error) {
+			var localState ChromeLocalState
+			err := json.Unmarshal(data, &localState)
+			return localState, err
+		})
+}
+
+// ReadChromeLastVersion returns the version of the browser that last used a
+// user data directory, empty when it is unknown
+func ReadChromeLastVersion(ctx context.Context, userDataDir string) string {
+	data, err := ReadFile(ctx, filepath.Join(userDataDir, LastVersionFile))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// profileDisplayName returns the name shown by the browser for a profile
+// directory, empty when Local State doesn't know it
+func profileDisplayName(ctx context.Context, profilePath string) string {
+	localState, err := ReadChromeLocalState(ctx, filepath.Dir(profilePath))
+	if err != nil {
+		return ""
+	}
+	return localState.Profile.InfoCache[filepath.Base(profilePath)].Name
+}
@@ -0,0 +1,485 @@
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"testing/fstest"
+
+	"github.com/osquery/osquery-go/plugin/table"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChromeTimeToUnix(t *testing.T) {
+	assert.Equal(t, int64(1712775205), ChromeTimeToUnix("13357248805228331"))
+	assert.Equal(t, int64(0), ChromeTimeToUnix(""))
+	assert.Equal(t, int64(0), ChromeTimeToUnix("not-a-number"))
+	assert.Equal(t, int64(0), ChromeTimeToUnix("0"))
+}
+
+// setupHomeDirs creates a fake home root with Chrome and Brave profiles for
+// several users and points HomeDirLocations at it for the test duration.
+func setupHomeDirs(t *testing.T) string {
+	homeRoot := t.TempDir()
+	originalLocations := HomeDirLocations[runtime.GOOS]
+	HomeDirLocations[runtime.GOOS] = []string{homeRoot}
+	t.Cleanup(func() { HomeDirLocations[runtime.GOOS] = originalLocations })
+	// Only use the accounts created below
+	originalPasswd := passwdFile
+	passwdFile = filepath.Join(homeRoot, "passwd")
+	t.Cleanup(func() { passwdFile = originalPasswd })
+
+	pathSuffixMap := GetChromePathSuffixMap()
+	profiles := []struct {
+		user    string
+		browser ChromeBrowserType
+		profile string
+	}{
+		{"alice", GoogleChrome, "Default"},
+		{"alice", GoogleChrome, "Profile 1"},
+		{"alice", Brave, "Default"},
+		{"bob", GoogleChrome, "Default"},
+	}
+	for _, p := range profiles {
+		profileDir := filepath.Join(homeRoot, p.user, pathSuffixMap[p.browser][0].Suffix, p.profile)
+		require.NoError(t, os.MkdirAll(profileDir, 0700))
+		require.NoError(t, os.WriteFile(filepath.Join(profileDir, ProfilePreferencesFile), []byte("{}"), 0600))
+	}
+	return homeRoot
+}
+
+// dirOwnerUID returns the uid expected for a home directory found by scanning
+// the home directory roots
+func dirOwnerUID(t *testing.T, path string) string {
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	return ownerUID(info)
+}
+
+func profileKeys(profiles []ChromeProfilePath) []string {
+	var keys []string
+	for _, p := range profiles {
+		keys = append(keys, p.UserName+"/"+GetChromeBrowserName(p.Type)+"/"+filepath.Base(p.Value))
+	}
+	return keys
+}
+
+func TestGetChromeProfilePathList(t *testing.T) {
+	setupHomeDirs(t)
+
+	profiles, err := GetChromeProfilePathList(context.Background())
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{
+		"alice/chrome/Default",
+		"alice/chrome/Profile 1",
+		"alice/brave/Default",
+		"bob/chrome/Default",
+	}, profileKeys(profiles))
+}
+
+func TestGetChromeProfilePathListFiltered(t *testing.T) {
+	setupHomeDirs(t)
+
+	profiles, err := GetChromeProfilePathList(context.Background(), WithUsernames("alice"), WithBrowserTypes("chrome"))
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"alice/chrome/Default", "alice/chrome/Profile 1"}, profileKeys(profiles))
+
+	profiles, err = GetChromeProfilePathList(context.Background(), WithProfiles("Default"), WithUsernames("bob", "carol"))
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"bob/chrome/Default"}, profileKeys(profiles))
+
+	queryContext := table.QueryContext{
+		Constraints: map[string]table.ConstraintList{
+			"browser_type": {Constraints: []table.Constraint{
+				{Operator: table.OperatorEquals, Expression: "brave"},
+				{Operator: table.OperatorEquals, Expression: "edge"},
+			}},
+			"user": {Constraints: []table.Constraint{
+				{Operator: table.OperatorLike, Expression: "b%"},
+			}},
+		},
+	}
+	profiles, err = GetChromeProfilePathList(context.Background(), ChromeProfileOptsFromQueryContext(queryContext)...)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"alice/brave/Default"}, profileKeys(profiles))
+}
+
+// recordingFileSystem records the paths looked at on the host filesystem
+type recordingFileSystem struct {
+	FileSystem
+	mu    sync.Mutex
+	paths []string
+}
+
+func (r *recordingFileSystem) record(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.paths = append(r.paths, name)
+}
+
+func (r *recordingFileSystem) Open(name string) (fs.File, error) {
+	r.record(name)
+	return r.FileSystem.Open(name)
+}
+
+func (r *recordingFileSystem) OpenInDir(dir, name string) (fs.File, error) {
+	r.record(filepath.Join(dir, name))
+	return r.FileSystem.OpenInDir(dir, name)
+}
+
+func (r *recordingFileSystem) ReadFile(name string) ([]byte, error) {
+	r.record(name)
+	return r.FileSystem.ReadFile(name)
+}
+
+func (r *recordingFileSystem) ReadDir(name string) ([]fs.DirEntry, error) {
+	r.record(name)
+	return r.FileSystem.ReadDir(name)
+}
+
+func (r *recordingFileSystem) Stat(name string) (fs.FileInfo, error) {
+	r.record(name)
+	return r.FileSystem.Stat(name)
+}
+
+func (r *recordingFileSystem) Glob(pattern string) ([]string, error) {
+	r.record(pattern)
+	return r.FileSystem.Glob(pattern)
+}
+
+func (r *recordingFileSystem) EvalSymlinks(name string) (string, error) {
+	r.record(name)
+	return r.FileSystem.EvalSymlinks(name)
+}
+
+func TestGetChromeProfilePathListFilteredUsersNotLookedAt(t *testing.T) {
+	homeRoot := setupHomeDirs(t)
+	fsys := &recordingFileSystem{FileSystem: OSFileSystem}
+	ctx := WithFileSystem(context.Background(), fsys)
+
+	profiles, err := GetChromeProfilePathList(ctx, WithUsernames("alice", "../bob"))
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"alice/chrome/Default", "alice/chrome/Profile 1", "alice/brave/Default"}, profileKeys(profiles))
+
+	// bob's home is neither listed nor stat'ed
+	bobHome := filepath.Join(homeRoot, "bob")
+	require.NotEmpty(t, fsys.paths)
+	for _, path := range fsys.paths {
+		assert.NotEqual(t, homeRoot, path)
+		assert.False(t, isWithin(bobHome, path), path)
+	}
+}
+
+func TestChromeProfileFromPath(t *testing.T) {
+	homeRoot := setupHomeDirs(t)
+	pathSuffixMap := GetChromePathSuffixMap()
+
+	preferencesFile := filepath.Join(homeRoot, "alice", pathSuffixMap[Brave][0].Suffix, "Default", ProfilePreferencesFile)
+	assert.Equal(t, ChromeProfilePath{
+		UserName:    "alice",
+		UID:         dirOwnerUID(t, filepath.Join(homeRoot, "alice")),
+		Home:        filepath.Join(homeRoot, "alice"),
+		Type:        Brave,
+		InstallType: NativeInstall,
+		Value:       filepath.Dir(preferencesFile),
+		UserDataDir: filepath.Join(homeRoot, "alice", pathSuffixMap[Brave][0].Suffix),
+	}, ChromeProfileFromPath(context.Background(), preferencesFile))
+
+	stateFile := filepath.Join(homeRoot, "bob", pathSuffixMap[GoogleChrome][0].Suffix, "Profile 2", "Network", "Network Persistent State")
+	assert.Equal(t, ChromeProfilePath{
+		UserName:    "bob",
+		UID:         dirOwnerUID(t, filepath.Join(homeRoot, "bob")),
+		Home:        filepath.Join(homeRoot, "bob"),
+		Type:        GoogleChrome,
+		InstallType: NativeInstall,
+		Value:       filepath.Join(homeRoot, "bob", pathSuffixMap[GoogleChrome][0].Suffix, "Profile 2"),
+		UserDataDir: filepath.Join(homeRoot, "bob", pathSuffixMap[GoogleChrome][0].Suffix),
+	}, ChromeProfileFromPath(context.Background(), stateFile))
+
+	// Files outside of the home directories keep user and browser empty
+	profile := ChromeProfileFromPath(context.Background(), filepath.Join(t.TempDir(), "case42", ProfilePreferencesFile))
+	assert.Equal(t, "", profile.UserName)
+	assert.Equal(t, "", GetChromeBrowserName(profile.Type))
+}
+
+func TestGetChromeProfilePathListWithRootDir(t *testing.T) {
+	homeRoot := setupHomeDirs(t)
+
+	// Home directory roots are looked up below the root directory
+	root := t.TempDir()
+	homes := HomeDirLocations[runtime.GOOS]
+	HomeDirLocations[runtime.GOOS] = []string{"/home"}
+	t.Cleanup(func() { HomeDirLocations[runtime.GOOS] = homes })
+	require.NoError(t, os.Rename(homeRoot, filepath.Join(root, "home")))
+
+	profiles, err := GetChromeProfilePathList(context.Background(), WithRootDir(root))
+	require.NoError(t, err)
+	assert.Len(t, profiles, 4)
+	for _, profile := range profiles {
+		assert.True(t, strings.HasPrefix(profile.Value, filepath.Join(root, "home")))
+	}
+}
+
+func TestGetChromeProfilePathListWithRootDirLinks(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("creating symlinks requires privileges on Windows")
+	}
+	root := t.TempDir()
+	homes := HomeDirLocations[runtime.GOOS]
+	HomeDirLocations[runtime.GOOS] = []string{"/home"}
+	t.Cleanup(func() { HomeDirLocations[runtime.GOOS] = homes })
+	SetRootDir(root)
+	t.Cleanup(func() { SetRootDir("") })
+
+	// The absolute links of the image are resolved in the image: /home is
+	// /var/home and alice's user data directory links to /home/alice/chrome
+	pathSuffixMap := GetChromePathSuffixMap()
+	home := filepath.Join(root, "var", "home", "alice")
+	chromeDir := filepath.Join(home, pathSuffixMap[GoogleChrome][0].Suffix)
+	require.NoError(t, os.MkdirAll(filepath.Join(home, "chrome", "Default"), 0700))
+	require.NoError(t, os.WriteFile(filepath.Join(home, "chrome", "Default", ProfilePreferencesFile), []byte(`{"a": "1"}`), 0600))
+	require.NoError(t, os.MkdirAll(filepath.Dir(chromeDir), 0700))
+	require.NoError(t, os.Symlink("/home/alice/chrome", chromeDir))
+	require.NoError(t, os.Symlink("/var/home", filepath.Join(root, "home")))
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "etc"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "etc", "passwd"), []byte("alice:x:1000:1000::/home/alice:/bin/sh\n"), 0644))
+
+	// A relative link leaving the image isn't followed to the live system
+	hostDir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(hostDir, "Default"), 0700))
+	require.NoError(t, os.WriteFile(filepath.Join(hostDir, "Default", ProfilePreferencesFile), []byte("{}"), 0600))
+	braveDir := filepath.Join(home, pathSuffixMap[Brave][0].Suffix)
+	require.NoError(t, os.MkdirAll(filepath.Dir(braveDir), 0700))
+	toHost, err := filepath.Rel(filepath.Dir(braveDir), hostDir)
+	require.NoError(t, err)
+	require.NoError(t, os.Symlink(toHost, braveDir))
+
+	profiles, err := GetChromeProfilePathList(context.Background())
+	require.NoError(t, err)
+	require.Len(t, profiles, 1)
+	assert.Equal(t, filepath.Join(home, "chrome", "Default"), profiles[0].Value)
+
+	// The home is resolved in the image to confine the reads too
+	ctx := WithOwner(context.Background(), UserAccount{Name: "alice", Home: filepath.Join(root, "home", "alice")})
+	data, err := ReadFile(ctx, filepath.Join(profiles[0].Value, ProfilePreferencesFile))
+	require.NoError(t, err)
+	assert.Equal(t, `{"a": "1"}`, string(data))
+}
+
+func TestEvalSymlinksInRoot(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("creating symlinks requires privileges on Windows")
+	}
+	root := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "var", "home"), 0755))
+	require.NoError(t, os.Symlink("/var/home", filepath.Join(root, "home")))
+	require.NoError(t, os.Symlink("../..", filepath.Join(root, "var", "up")))
+	require.NoError(t, os.Symlink("loop", filepath.Join(root, "loop")))
+
+	resolved, err := evalSymlinks(OSFileSystem, root, filepath.Join(root, "home"))
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(root, "var", "home"), resolved)
+
+	_, err = evalSymlinks(OSFileSystem, root, filepath.Join(root, "var", "up", "etc"))
+	assert.ErrorIs(t, err, errOutsideRoot)
+	_, err = evalSymlinks(OSFileSystem, root, filepath.Join(root, "loop"))
+	assert.Error(t, err)
+	_, err = evalSymlinks(OSFileSystem, root, t.TempDir())
+	assert.ErrorIs(t, err, errOutsideRoot)
+}
+
+func TestRootPath(t *testing.T) {
+	assert.Equal(t, "/home/alice", RootPath("/home/alice"))
+
+	SetRootDir("/mnt/image")
+	t.Cleanup(func() { SetRootDir("") })
+	assert.Equal(t, filepath.Join("/mnt/image", "home", "alice"), RootPath("/home/alice"))
+}
+
+func TestGetChromeProfilePathListOnFileSystem(t *testing.T) {
+	homes := HomeDirLocations[runtime.GOOS]
+	HomeDirLocations[runtime.GOOS] = []string{"/home"}
+	t.Cleanup(func() { HomeDirLocations[runtime.GOOS] = homes })
+
+	pathSuffixMap := GetChromePathSuffixMap()
+	profile := func(user string, browser ChromeBrowserType, profile, file string) string {
+		return filepath.ToSlash(filepath.Join("home", user, pathSuffixMap[browser][0].Suffix, profile, file))
+	}
+	ctx := WithFileSystem(context.Background(), NewFileSystem(fstest.MapFS{
+		"etc/passwd": {Data: []byte("alice:x:1000:1000::/home/alice:/bin/sh\nbob:x:1001:1001::/home/bob:/bin/sh\n")},
+		profile("alice", GoogleChrome, "Default", ProfilePreferencesFile):         {Data: []byte("{}")},
+		profile("alice", GoogleChrome, "Profile 1", SecureProfilePreferencesFile): {Data: []byte("{}")},
+		profile("alice", Vivaldi, "Default", ProfilePreferencesFile):              {Data: []byte("{}")},
+		profile("bob", Brave, "Default", ProfilePreferencesFile):                  {Data: []byte("{}")},
+		// Not a profile, there's no preferences file
+		profile("bob", GoogleChrome, "Crashpad", "settings.dat"): {Data: []byte{}},
+	}))
+
+	profiles, err := GetChromeProfilePathList(ctx)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{
+		"alice/chrome/Default",
+		"alice/chrome/Profile 1",
+		"alice/vivaldi/Default",
+		"bob/brave/Default",
+	}, profileKeys(profiles))
+	if runtime.GOOS != "windows" {
+		for _, p := range profiles {
+			assert.Equal(t, map[string]string{"alice": "1000", "bob": "1001"}[p.UserName], p.UID)
+		}
+	}
+}
+
+func TestGetChromeProfilePathListFromLocalState(t *testing.T) {
+	homes := HomeDirLocations[runtime.GOOS]
+	HomeDirLocations[runtime.GOOS] = []string{"/home"}
+	t.Cleanup(func() { HomeDirLocations[runtime.GOOS] = homes })
+
+	pathSuffixMap := GetChromePathSuffixMap()
+	profile := func(user string, browser ChromeBrowserType, profile, file string) string {
+		return filepath.ToSlash(filepath.Join("home", user, pathSuffixMap[browser][0].Suffix, profile, file))
+	}
+	ctx := WithFileSystem(context.Background(), NewFileSystem(fstest.MapFS{
+		"etc/passwd": {Data: []byte("alice:x:1000:1000::/home/alice:/bin/sh\n")},
+		profile("alice", GoogleChrome, "", LocalStateFile): {Data: []byte(`{"profile": {"info_cache": {
+			"Default": {"name": "Personal"},
+			"Profile 3": {"name": "Work"},
+			"Profile 4": {"name": "Removed"}
+		}}}`)},
+		profile("alice", GoogleChrome, "Default", ProfilePreferencesFile):   {Data: []byte("{}")},
+		profile("alice", GoogleChrome, "Profile 3", ProfilePreferencesFile): {Data: []byte("{}")},
+		// Not in Local State, the browser doesn't use it anymore
+		profile("alice", GoogleChrome, "Profile 1", ProfilePreferencesFile): {Data: []byte("{}")},
+		// Without Local State every directory is checked, but the system
+		// and guest profiles
+		profile("alice", Brave, "Default", ProfilePreferencesFile):        {Data: []byte("{}")},
+		profile("alice", Brave, "System Profile", ProfilePreferencesFile): {Data: []byte("{}")},
+		profile("alice", Brave, "Guest Profile", ProfilePreferencesFile):  {Data: []byte("{}")},
+	}))
+
+	profiles, err := GetChromeProfilePathList(ctx)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{
+		"alice/chrome/Default",
+		"alice/chrome/Profile 3",
+		"alice/brave/Default",
+	}, profileKeys(profiles))
+	names := map[string]string{}
+	for _, p := range profiles {
+		names[GetChromeBrowserName(p.Type)+"/"+filepath.Base(p.Value)] = p.Name
+		assert.Equal(t, filepath.Dir(p.Value), p.UserDataDir)
+	}
+	assert.Equal(t, map[string]string{
+		"chrome/Default":   "Personal",
+		"chrome/Profile 3": "Work",
+		"brave/Default":    "",
+	}, names)
+
+	statePath := filepath.Join(string(filepath.Separator), profile("alice", GoogleChrome, "Profile 3", ProfilePreferencesFile))
+	assert.Equal(t, "Work", ChromeProfileFromPath(ctx, statePath).Name)
+}
+
+func TestGetChromeProfilePathListHostileLocalState(t *testing.T) {
+	homes := HomeDirLocations[runtime.GOOS]
+	HomeDirLocations[runtime.GOOS] = []string{"/home"}
+	t.Cleanup(func() { HomeDirLocations[runtime.GOOS] = homes })
+
+	suffix := GetChromePathSuffixMap()[GoogleChrome][0].Suffix
+	aliceDir := filepath.Join("/home", "alice", suffix)
+	bobProfile := filepath.Join("/home", "bob", suffix, "Default")
+	toBob, err := filepath.Rel(aliceDir, bobProfile)
+	require.NoError(t, err)
+	localState, err := json.Marshal(map[string]any{"profile": map[string]any{"info_cache": map[string]any{
+		"Default":   map[string]string{"name": "Personal"},
+		toBob:       map[string]string{"name": "Relative"},
+		bobProfile:  map[string]string{"name": "Absolute"},
+		"..":        map[string]string{"name": "Parent"},
+		"Default/.": map[string]string{"name": "Dot"},
+	}}})
+	require.NoError(t, err)
+
+	ctx := WithFileSystem(context.Background(), NewFileSystem(fstest.MapFS{
+		"etc/passwd": {Data: []byte("alice:x:1000:1000::/home/alice:/bin/sh\nbob:x:1001:1001::/home/bob:/bin/sh\n")},
+		filepath.ToSlash(filepath.Join(aliceDir[1:], LocalStateFile)):                    {Data: localState},
+		filepath.ToSlash(filepath.Join(aliceDir[1:], "Default", ProfilePreferencesFile)): {Data: []byte("{}")},
+		filepath.ToSlash(filepath.Join(bobProfile[1:], ProfilePreferencesFile)):          {Data: []byte("{}")},
+		filepath.ToSlash(filepath.Join("home", "alice", ProfilePreferencesFile)):         {Data: []byte("{}")},
+	}))
+
+	// Only alice's own profile is returned, the keys leading elsewhere are
+	// ignored
+	profiles, err := GetChromeProfilePathList(ctx, WithUsernames("alice"))
+	require.NoError(t, err)
+	require.Len(t, profiles, 1)
+	assert.Equal(t, filepath.Join(aliceDir, "Default"), profiles[0].Value)
+	assert.Equal(t, "Personal", profiles[0].Name)
+}
+
+func TestGetChromeProfilePathListInstallTypes(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("Snap and Flatpak are Linux only")
+	}
+	homes := HomeDirLocations[runtime.GOOS]
+	HomeDirLocations[runtime.GOOS] = []string{"/home"}
+	t.Cleanup(func() { HomeDirLocations[runtime.GOOS] = homes })
+
+	ctx := WithFileSystem(context.Background(), NewFileSystem(fstest.MapFS{
+		"etc/passwd": {Data: []byte("alice:x:1000:1000::/home/alice:/bin/sh\n")},
+		"home/alice/.config/chromium-browser/Default/Preferences":                                 {Data: []byte("{}")},
+		"home/alice/.config/microsoft-edge/Default/Preferences":                                   {Data: []byte("{}")},
+		"home/alice/snap/chromium/common/chromium/Default/Preferences":                            {Data: []byte("{}")},
+		"home/alice/snap/brave/current/.config/BraveSoftware/Brave-Browser/Default/Preferences":   {Data: []byte("{}")},
+		"home/alice/.var/app/com.google.Chrome/config/google-chrome/Profile 1/Preferences":        {Data: []byte("{}")},
+		"home/alice/.var/app/com.microsoft.EdgeDev/config/microsoft-edge-dev/Default/Preferences": {Data: []byte("{}")},
+	}))
+
+	installTypes := func(profiles []ChromeProfilePath) []string {
+		var keys []string
+		for _, p := range profiles {
+			keys = append(keys, GetChromeBrowserName(p.Type)+"/"+string(p.InstallType)+"/"+filepath.Base(p.Value))
+		}
+		return keys
+	}
+
+	profiles, err := GetChromeProfilePathList(ctx)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{
+		"chromium/native/Default",
+		"edge/native/Default",
+		"chromium/snap/Default",
+		"brave/snap/Default",
+		"chrome/flatpak/Profile 1",
+		"edge_dev/flatpak/Default",
+	}, installTypes(profiles))
+
+	profiles, err = GetChromeProfilePathList(ctx, WithInstallTypes("snap"), WithBrowserTypes("chromium"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"chromium/snap/Default"}, installTypes(profiles))
+
+	profile := ChromeProfileFromPath(ctx, "/home/alice/.var/app/com.google.Chrome/config/google-chrome/Profile 1/Preferences")
+	assert.Equal(t, GoogleChrome, profile.Type)
+	assert.Equal(t, FlatpakInstall, profile.InstallType)
+}
+
+func TestGetChromeProfilePathListLinkedUserDataDir(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("chromium-browser is a Linux location")
+	}
+	homeRoot := setupHomeDirs(t)
+	chromiumDir := filepath.Join(homeRoot, "bob", ".config", "chromium")
+	require.NoError(t, os.MkdirAll(filepath.Join(chromiumDir, "Default"), 0700))
+	require.NoError(t, os.WriteFile(filepath.Join(chromiumDir, "Default", ProfilePreferencesFile), []byte("{}"), 0600))
+	require.NoError(t, os.Symlink(chromiumDir, filepath.Join(homeRoot, "bob", ".config", "chromium-browser")))
+
+	// The profiles of a linked user data directory are only returned once
+	profiles, err := GetChromeProfilePathList(context.Background(), WithUsernames("bob"), WithBrowserTypes("chromium"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"bob/chromium/Default"}, profileKeys(profiles))
+}
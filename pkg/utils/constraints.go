@@ -0,0 +1,23 @@
+package utils
+
+import (
+	"github.com/osquery/osquery-go/plugin/table"
+)
+
+// GetConstraintValues returns the values of the equality constraints on a
+// column. osquery passes IN constraints as several equality constraints, so
+// the values are alternatives and a row matching any of them is expected.
+func GetConstraintValues(queryContext table.QueryContext, column string) []string {
+	constraintList, ok := queryContext.Constraints[column]
+	if !ok {
+		return nil
+	}
+
+	var values []string
+	for _, constraint := range constraintList.Constraints {
+		if constraint.Operator == table.OperatorEquals {
+			values = append(values, constraint.Expression)
+		}
+	}
+	return values
+}
@@ -0,0 +1,128 @@
+package utils
+
+import (
+	"context"
+	"io/fs"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Error classes reported by the osquery_extension_errors table
+const (
+	ErrorClassNotFound         = "not_found"
+	ErrorClassPermissionDenied = "permission_denied"
+	ErrorClassParseError       = "parse_error"
+	ErrorClassTooLarge         = "too_large"
+	ErrorClassTimeout          = "timeout"
+	ErrorClassRefused          = "refused"
+)
+
+// ErrFileTooLarge is returned when a file is over the size the tables are
+// willing to parse
+var ErrFileTooLarge = errors.New("file too large")
+
+// maxRecordedErrors bounds the memory used by the error log, older errors
+// are dropped first
+const maxRecordedErrors = 1000
+
+// TableError is a failure a table encountered while generating its rows
+type TableError struct {
+	Table     string
+	User      string
+	Path      string
+	Class     string
+	Message   string
+	Timestamp time.Time
+}
+
+// errorLog is a ring buffer of the most recent table errors
+type errorLog struct {
+	mu      sync.Mutex
+	entries []TableError
+	next    int
+	full    bool
+}
+
+func newErrorLog(size int) *errorLog {
+	return &errorLog{entries: make([]TableError, size)}
+}
+
+func (l *errorLog) add(e TableError) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.entries[l.next] = e
+	l.next = (l.next + 1) % len(l.entries)
+	if l.next == 0 {
+		l.full = true
+	}
+}
+
+// list returns the errors from the oldest to the most recent
+func (l *errorLog) list() []TableError {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.full {
+		return append([]TableError{}, l.entries[:l.next]...)
+	}
+	return append(append([]TableError{}, l.entries[l.next:]...), l.entries[:l.next]...)
+}
+
+var tableErrors = newErrorLog(maxRecordedErrors)
+
+// ClassifyError returns the class of an error returned while reading and
+// parsing a file.
+func ClassifyError(err error) string {
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return ErrorClassNotFound
+	case errors.Is(err, fs.ErrPermission):
+		return ErrorClassPermissionDenied
+	case errors.Is(err, ErrFileTooLarge):
+		return ErrorClassTooLarge
+	case errors.Is(err, ErrOutsideHome), errors.Is(err, ErrNotRegularFile), errors.Is(err, ErrNotOwned):
+		return ErrorClassRefused
+	case errors.Is(err, context.DeadlineExceeded):
+		return ErrorClassTimeout
+	}
+	// The file could be read but its content isn't what we expected
+	return ErrorClassParseError
+}
+
+// RecordError adds an error to the log read by the osquery_extension_errors
+// table. path is the file or profile being parsed, the path of the failing
+// file is used instead when the error carries one.
+//
+// The files SafeOpen refuses to read are recorded when they are refused, they
+// are not recorded again.
+func RecordError(tableName, user, path string, err error) {
+	var refused *refusedError
+	if errors.As(err, &refused) {
+		return
+	}
+	recordError(tableName, user, path, err)
+}
+
+func recordError(tableName, user, path string, err error) {
+	if err == nil {
+		return
+	}
+	var pathError *fs.PathError
+	if errors.As(err, &pathError) {
+		path = pathError.Path
+	}
+	tableErrors.add(TableError{
+		Table:     tableName,
+		User:      user,
+		Path:      path,
+		Class:     ClassifyError(err),
+		Message:   err.Error(),
+		Timestamp: time.Now(),
+	})
+}
+
+// RecentErrors returns the recorded errors from the oldest to the most recent
+func RecentErrors() []TableError {
+	return tableErrors.list()
+}
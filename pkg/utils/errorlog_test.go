@@ -0,0 +1,60 @@
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestErrorLogIsBounded(t *testing.T) {
+	log := newErrorLog(3)
+	assert.Empty(t, log.list())
+
+	for i := 0; i < 5; i++ {
+		log.add(TableError{Message: strconv.Itoa(i)})
+	}
+	var messages []string
+	for _, e := range log.list() {
+		messages = append(messages, e.Message)
+	}
+	assert.Equal(t, []string{"2", "3", "4"}, messages)
+}
+
+func TestClassifyError(t *testing.T) {
+	_, err := os.ReadFile(filepath.Join(t.TempDir(), "missing"))
+	assert.Equal(t, ErrorClassNotFound, ClassifyError(errors.Wrap(err, "reading file")))
+
+	assert.Equal(t, ErrorClassTooLarge, ClassifyError(errors.Wrap(ErrFileTooLarge, "reading file")))
+	assert.Equal(t, ErrorClassTimeout, ClassifyError(errors.Wrap(context.DeadlineExceeded, "scanning profile")))
+	assert.Equal(t, ErrorClassRefused, ClassifyError(errors.Wrap(ErrOutsideHome, "reading file")))
+	assert.Equal(t, ErrorClassRefused, ClassifyError(errors.Wrap(ErrNotRegularFile, "reading file")))
+
+	var value map[string]interface{}
+	err = json.Unmarshal([]byte("{"), &value)
+	assert.Equal(t, ErrorClassParseError, ClassifyError(errors.Wrap(err, "unmarshalling file")))
+}
+
+func TestRecordError(t *testing.T) {
+	missing := filepath.Join(t.TempDir(), "Preferences")
+	_, err := os.ReadFile(missing)
+	require.Error(t, err)
+
+	RecordError("test_table", "alice", "/profile", errors.Wrap(err, "reading file"))
+	RecordError("test_table", "alice", "/profile", nil)
+
+	errs := RecentErrors()
+	require.NotEmpty(t, errs)
+	last := errs[len(errs)-1]
+	assert.Equal(t, "test_table", last.Table)
+	assert.Equal(t, "alice", last.User)
+	// The path of the failing file is preferred over the profile
+	assert.Equal(t, missing, last.Path)
+	assert.Equal(t, ErrorClassNotFound, last.Class)
+}
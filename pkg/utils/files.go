@@ -0,0 +1,257 @@
+package utils
+
+import (
+	"context"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"syscall"
+
+	"github.com/osquery/osquery-go/plugin/table"
+	"github.com/pkg/errors"
+)
+
+// FileSystem is the filesystem the tables discover and read their files on.
+// Paths are host paths, already prefixed with the root directory.
+type FileSystem interface {
+	Open(name string) (fs.File, error)
+	// OpenInDir opens name, relative to dir, without following any link
+	// leaving dir, even for a moment. Absolute links are not followed.
+	OpenInDir(dir, name string) (fs.File, error)
+	ReadFile(name string) ([]byte, error)
+	ReadDir(name string) ([]fs.DirEntry, error)
+	Stat(name string) (fs.FileInfo, error)
+	Lstat(name string) (fs.FileInfo, error)
+	Readlink(name string) (string, error)
+	Glob(pattern string) ([]string, error)
+	EvalSymlinks(name string) (string, error)
+}
+
+// errOutsideDir is returned by OpenInDir for the paths leaving the directory
+var errOutsideDir = errors.New("path resolves outside of the directory")
+
+// OSFileSystem is the filesystem of the host, used unless another one is set
+// with WithFileSystem
+var OSFileSystem FileSystem = osFileSystem{}
+
+type osFileSystem struct{}
+
+func (osFileSystem) ReadFile(name string) ([]byte, error)       { return os.ReadFile(name) }
+func (osFileSystem) ReadDir(name string) ([]fs.DirEntry, error) { return os.ReadDir(name) }
+func (osFileSystem) Stat(name string) (fs.FileInfo, error)      { return os.Stat(name) }
+func (osFileSystem) Lstat(name string) (fs.FileInfo, error)     { return os.Lstat(name) }
+func (osFileSystem) Readlink(name string) (string, error)       { return os.Readlink(name) }
+func (osFileSystem) Glob(pattern string) ([]string, error)      { return filepath.Glob(pattern) }
+func (osFileSystem) EvalSymlinks(name string) (string, error)   { return filepath.EvalSymlinks(name) }
+
+// Open doesn't block on FIFOs, which SafeOpen then refuses
+func (osFileSystem) Open(name string) (fs.File, error) {
+	return os.OpenFile(name, openFlags, 0)
+}
+
+// OpenInDir resolves name one component at a time with os.Root, a directory
+// swapped for a link to another one can't redirect the open
+func (osFileSystem) OpenInDir(dir, name string) (fs.File, error) {
+	root, err := os.OpenRoot(dir)
+	if err != nil {
+		return nil, err
+	}
+	defer root.Close()
+	file, err := root.OpenFile(name, openFlags, 0)
+	if err != nil {
+		// os.Root reports the paths leaving dir with an error of its own
+		var errno syscall.Errno
+		if !errors.As(err, &errno) && !errors.Is(err, fs.ErrNotExist) {
+			return nil, &fs.PathError{Op: "open", Path: filepath.Join(dir, name), Err: errOutsideDir}
+		}
+		return nil, err
+	}
+	return file, nil
+}
+
+// ioFileSystem serves host paths from an fs.FS rooted at /
+type ioFileSystem struct {
+	fsys fs.FS
+}
+
+// NewFileSystem returns a FileSystem serving the files of fsys as if it was
+// mounted on /, e.g. an fstest.MapFS with a home/alice entry is seen as
+// /home/alice. fs.FS has no symlinks, EvalSymlinks only checks the path
+// exists.
+func NewFileSystem(fsys fs.FS) FileSystem {
+	return ioFileSystem{fsys: fsys}
+}
+
+// name converts a host path to an fs.FS path
+func (f ioFileSystem) name(op, hostPath string) (string, error) {
+	name := filepath.ToSlash(strings.TrimPrefix(hostPath, filepath.VolumeName(hostPath)))
+	name = strings.TrimPrefix(path.Clean("/"+name), "/")
+	if name == "" {
+		name = "."
+	}
+	if !fs.ValidPath(name) {
+		return "", &fs.PathError{Op: op, Path: hostPath, Err: fs.ErrInvalid}
+	}
+	return name, nil
+}
+
+// hostError reports errors with the host path rather than the fs.FS one
+func hostError(err error, hostPath string) error {
+	if pathErr, ok := err.(*fs.PathError); ok {
+		return &fs.PathError{Op: pathErr.Op, Path: hostPath, Err: pathErr.Err}
+	}
+	return err
+}
+
+func (f ioFileSystem) Open(hostPath string) (fs.File, error) {
+	name, err := f.name("open", hostPath)
+	if err != nil {
+		return nil, err
+	}
+	file, err := f.fsys.Open(name)
+	return file, hostError(err, hostPath)
+}
+
+func (f ioFileSystem) OpenInDir(dir, name string) (fs.File, error) {
+	// fs.FS has no links, only .. can leave dir
+	if !filepath.IsLocal(name) {
+		return nil, &fs.PathError{Op: "open", Path: filepath.Join(dir, name), Err: errOutsideDir}
+	}
+	return f.Open(filepath.Join(dir, name))
+}
+
+func (f ioFileSystem) ReadFile(hostPath string) ([]byte, error) {
+	name, err := f.name("open", hostPath)
+	if err != nil {
+		return nil, err
+	}
+	data, err := fs.ReadFile(f.fsys, name)
+	return data, hostError(err, hostPath)
+}
+
+func (f ioFileSystem) ReadDir(hostPath string) ([]fs.DirEntry, error) {
+	name, err := f.name("open", hostPath)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := fs.ReadDir(f.fsys, name)
+	return entries, hostError(err, hostPath)
+}
+
+func (f ioFileSystem) Stat(hostPath string) (fs.FileInfo, error) {
+	name, err := f.name("stat", hostPath)
+	if err != nil {
+		return nil, err
+	}
+	info, err := fs.Stat(f.fsys, name)
+	return info, hostError(err, hostPath)
+}
+
+// Lstat is Stat, fs.FS has no symlinks
+func (f ioFileSystem) Lstat(hostPath string) (fs.FileInfo, error) {
+	return f.Stat(hostPath)
+}
+
+func (f ioFileSystem) Readlink(hostPath string) (string, error) {
+	return "", &fs.PathError{Op: "readlink", Path: hostPath, Err: fs.ErrInvalid}
+}
+
+func (f ioFileSystem) Glob(pattern string) ([]string, error) {
+	name, err := f.name("glob", pattern)
+	if err != nil {
+		return nil, err
+	}
+	matches, err := fs.Glob(f.fsys, name)
+	if err != nil {
+		return nil, err
+	}
+	for i, match := range matches {
+		matches[i] = filepath.FromSlash("/" + match)
+	}
+	return matches, nil
+}
+
+func (f ioFileSystem) EvalSymlinks(hostPath string) (string, error) {
+	if _, err := f.Stat(hostPath); err != nil {
+		return "", err
+	}
+	return filepath.Clean(hostPath), nil
+}
+
+type fileSystemKey struct{}
+
+// WithFileSystem returns a context in which the files are discovered and read
+// on fsys
+func WithFileSystem(ctx context.Context, fsys FileSystem) context.Context {
+	return context.WithValue(ctx, fileSystemKey{}, fsys)
+}
+
+// FileSystemFromContext returns the filesystem set with WithFileSystem, the
+// host filesystem by default
+func FileSystemFromContext(ctx context.Context) FileSystem {
+	if fsys, ok := ctx.Value(fileSystemKey{}).(FileSystem); ok {
+		return fsys
+	}
+	return OSFileSystem
+}
+
+// GenerateWithFileSystem returns a generate function running generate on
+// fsys. The tables use it to build their generate function constructors.
+func GenerateWithFileSystem(fsys FileSystem, generate table.GenerateFunc) table.GenerateFunc {
+	return func(ctx context.Context, queryContext table.QueryContext) ([]map[string]string, error) {
+		return generate(WithFileSystem(ctx, fsys), queryContext)
+	}
+}
+
+// ReadStats counts the files read and bytes parsed by a table while it
+// generates its rows, and the lookups of the parsed file cache
+type ReadStats struct {
+	Files       atomic.Int64
+	Bytes       atomic.Int64
+	CacheHits   atomic.Int64
+	CacheMisses atomic.Int64
+}
+
+type readStatsKey struct{}
+
+// WithReadStats returns a context in which ReadFile accounts for the files it
+// reads in stats
+func WithReadStats(ctx context.Context, stats *ReadStats) context.Context {
+	return context.WithValue(ctx, readStatsKey{}, stats)
+}
+
+func readStatsFromContext(ctx context.Context) *ReadStats {
+	stats, _ := ctx.Value(readStatsKey{}).(*ReadStats)
+	return stats
+}
+
+// ReadFile reads a file on the filesystem of the context. Every table reads
+// its files through it so the reads are accounted for and the file is opened
+// with SafeOpen. Nothing is read once the context is done.
+func ReadFile(ctx context.Context, path string) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	file, err := SafeOpen(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return readOpened(ctx, path, file)
+}
+
+// readOpened reads a file opened with SafeOpen and accounts for the read
+func readOpened(ctx context.Context, path string, file fs.File) ([]byte, error) {
+	data, err := readLimited(ctx, path, file)
+	if err != nil {
+		return nil, err
+	}
+	if stats := readStatsFromContext(ctx); stats != nil {
+		stats.Files.Add(1)
+		stats.Bytes.Add(int64(len(data)))
+	}
+	return data, nil
+}
@@ -0,0 +1,67 @@
+package utils
+
+import (
+	"context"
+	"io/fs"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileSystem(t *testing.T) {
+	fsys := NewFileSystem(fstest.MapFS{
+		"home/alice/notes.txt":      {Data: []byte("hello")},
+		"home/alice/.config/a.json": {Data: []byte("{}")},
+		"home/alice/.config/b.json": {Data: []byte("{}")},
+	})
+
+	data, err := fsys.ReadFile(filepath.FromSlash("/home/alice/notes.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(data))
+
+	// Errors carry the host path
+	_, err = fsys.ReadFile(filepath.FromSlash("/home/bob/notes.txt"))
+	var pathErr *fs.PathError
+	require.ErrorAs(t, err, &pathErr)
+	assert.Equal(t, filepath.FromSlash("/home/bob/notes.txt"), pathErr.Path)
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+
+	entries, err := fsys.ReadDir(filepath.FromSlash("/home/"))
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, "alice", entries[0].Name())
+
+	info, err := fsys.Stat(filepath.FromSlash("/home/alice"))
+	require.NoError(t, err)
+	assert.True(t, info.IsDir())
+
+	matches, err := fsys.Glob(filepath.FromSlash("/home/*/.config/*.json"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		filepath.FromSlash("/home/alice/.config/a.json"),
+		filepath.FromSlash("/home/alice/.config/b.json"),
+	}, matches)
+
+	resolved, err := fsys.EvalSymlinks(filepath.FromSlash("/home/alice/.config/"))
+	require.NoError(t, err)
+	assert.Equal(t, filepath.FromSlash("/home/alice/.config"), resolved)
+	_, err = fsys.EvalSymlinks(filepath.FromSlash("/home/bob"))
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+}
+
+func TestReadFileFromContext(t *testing.T) {
+	fsys := NewFileSystem(fstest.MapFS{"etc/hostname": {Data: []byte("case42\n")}})
+	stats := &ReadStats{}
+	ctx := WithReadStats(WithFileSystem(context.Background(), fsys), stats)
+
+	data, err := ReadFile(ctx, filepath.FromSlash("/etc/hostname"))
+	require.NoError(t, err)
+	assert.Equal(t, "case42\n", string(data))
+	assert.Equal(t, int64(1), stats.Files.Load())
+	assert.Equal(t, int64(7), stats.Bytes.Load())
+
+	assert.Equal(t, OSFileSystem, FileSystemFromContext(context.Background()))
+}
@@ -0,0 +1,19 @@
+//go:build !windows
+// +build !windows
+
+package utils
+
+import (
+	"io/fs"
+	"syscall"
+)
+
+// fileID returns the device and inode of a file, 0 when the filesystem
+// doesn't report them
+func fileID(info fs.FileInfo) (uint64, uint64) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, 0
+	}
+	return uint64(stat.Dev), uint64(stat.Ino)
+}
@@ -0,0 +1,12 @@
+//go:build windows
+// +build windows
+
+package utils
+
+import "io/fs"
+
+// fileID is not available from a FileInfo on Windows, files are told apart by
+// their modification time and size
+func fileID(info fs.FileInfo) (uint64, uint64) {
+	return 0, 0
+}
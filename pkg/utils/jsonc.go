@@ -0,0 +1,91 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+// StandardizeJSONC converts JSON with comments (JSONC), as used by VS Code
+// settings files, into standard JSON. It removes line and block comments,
+// trailing commas and the UTF-8 byte order mark. Comments are replaced with
+// whitespace so offsets in error messages still point to the original input.
+func StandardizeJSONC(data []byte) []byte {
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+	output := make([]byte, 0, len(data))
+
+	inString := false
+	for i := 0; i < len(data); i++ {
+		c := data[i]
+
+		if inString {
+			output = append(output, c)
+			switch c {
+			case '\\':
+				// Copy the escaped character as is
+				if i+1 < len(data) {
+					i++
+					output = append(output, data[i])
+				}
+			case '"':
+				inString = false
+			}
+			continue
+		}
+
+		switch {
+		case c == '"':
+			inString = true
+			output = append(output, c)
+		case c == '/' && i+1 < len(data) && data[i+1] == '/':
+			// Line comment, skip up to the end of the line
+			for i < len(data) && data[i] != '\n' {
+				output = append(output, ' ')
+				i++
+			}
+			if i < len(data) {
+				output = append(output, '\n')
+			}
+		case c == '/' && i+1 < len(data) && data[i+1] == '*':
+			// Block comment, keep new lines
+			output = append(output, ' ', ' ')
+			i += 2
+			for i < len(data) && !(data[i] == '*' && i+1 < len(data) && data[i+1] == '/') {
+				if data[i] == '\n' {
+					output = append(output, '\n')
+				} else {
+					output = append(output, ' ')
+				}
+				i++
+			}
+			if i < len(data) {
+				output = append(output, ' ', ' ')
+				i++
+			}
+		case c == '}' || c == ']':
+			// Drop a trailing comma before the closing bracket
+			for j := len(output) - 1; j >= 0; j-- {
+				if isJSONWhitespace(output[j]) {
+					continue
+				}
+				if output[j] == ',' {
+					output[j] = ' '
+				}
+				break
+			}
+			output = append(output, c)
+		default:
+			output = append(output, c)
+		}
+	}
+
+	return output
+}
+
+func isJSONWhitespace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
+
+// UnmarshalJSONC parses JSON with comments and trailing commas into v.
+func UnmarshalJSONC(data []byte, v interface{}) error {
+	return json.Unmarshal(StandardizeJSONC(data), v)
+}
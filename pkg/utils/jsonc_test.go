@@ -0,0 +1,39 @@
+package utils
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUnmarshalJSONC(t *testing.T) {
+	input := "\xef\xbb\xbf" + `{
+	// Line comment
+	"http.proxy": "http://proxy.example.com:3128", // trailing comment
+	/* Block
+	   comment */
+	"url": "https://example.com/*not-a-comment*/",
+	"escaped": "quote \" and // slashes",
+	"list": [1, 2, 3,],
+	"nested": {
+		"a": true,
+	},
+}`
+
+	var value map[string]interface{}
+	require.NoError(t, UnmarshalJSONC([]byte(input), &value))
+	assert.Equal(t, map[string]interface{}{
+		"http.proxy": "http://proxy.example.com:3128",
+		"url":        "https://example.com/*not-a-comment*/",
+		"escaped":    `quote " and // slashes`,
+		"list":       []interface{}{float64(1), float64(2), float64(3)},
+		"nested":     map[string]interface{}{"a": true},
+	}, value)
+}
+
+func TestUnmarshalJSONCInvalid(t *testing.T) {
+	var value map[string]interface{}
+	assert.Error(t, UnmarshalJSONC([]byte(`{"a": }`), &value))
+	assert.Error(t, UnmarshalJSONC([]byte(`{"a": 1 /* unterminated`), &value))
+}
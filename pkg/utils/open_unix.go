@@ -0,0 +1,12 @@
+//go:build !windows
+// +build !windows
+
+package utils
+
+import (
+	"os"
+	"syscall"
+)
+
+// openFlags opens FIFOs without waiting for a writer
+const openFlags = os.O_RDONLY | syscall.O_NONBLOCK
@@ -0,0 +1,9 @@
+//go:build windows
+// +build windows
+
+package utils
+
+import "os"
+
+// openFlags opens the files read only, there are no FIFOs to worry about
+const openFlags = os.O_RDONLY
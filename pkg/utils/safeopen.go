@@ -0,0 +1,222 @@
+package utils
+
+import (
+	"context"
+	"io"
+	"io/fs"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultMaxFileSize is the size over which the tables refuse to read a file
+const DefaultMaxFileSize = 32 << 20
+
+// ErrOutsideHome is returned for a file of a user that resolves outside of
+// the user's home directory, e.g. a Preferences symlink to /etc/shadow
+var ErrOutsideHome = errors.New("path resolves outside of the user's home directory")
+
+// ErrNotRegularFile is returned for devices, FIFOs, sockets and directories
+var ErrNotRegularFile = errors.New("not a regular file")
+
+// ErrNotOwned is returned for a directory used by a user but owned by another
+// one, e.g. a workspace folder opened in another user's project
+var ErrNotOwned = errors.New("not owned by the user")
+
+var maxFileSize atomic.Int64
+
+func init() {
+	maxFileSize.Store(DefaultMaxFileSize)
+}
+
+// SetMaxFileSize sets the size over which the tables refuse to read a file. A
+// size of 0 removes the limit.
+func SetMaxFileSize(size int64) {
+	maxFileSize.Store(size)
+}
+
+// GetMaxFileSize returns the size over which the tables refuse to read a file,
+// 0 when there is no limit
+func GetMaxFileSize() int64 {
+	return maxFileSize.Load()
+}
+
+// refusedError is returned by SafeOpen for a file it refuses to open. The
+// refusal is recorded when it happens, RecordError ignores it afterwards so
+// the callers handling it like any read error don't record it twice.
+type refusedError struct {
+	err error
+}
+
+func (e *refusedError) Error() string { return e.err.Error() }
+func (e *refusedError) Unwrap() error { return e.err }
+
+type ownerKey struct{}
+
+// WithOwner returns a context in which the files read belong to account.
+// SafeOpen refuses the files resolving outside of its home directory.
+func WithOwner(ctx context.Context, account UserAccount) context.Context {
+	return context.WithValue(ctx, ownerKey{}, account)
+}
+
+func ownerFromContext(ctx context.Context) (UserAccount, bool) {
+	account, ok := ctx.Value(ownerKey{}).(UserAccount)
+	return account, ok && account.Home != ""
+}
+
+type extensionDirKey struct{}
+
+// withExtensionDir returns a context in which the files below dir are read
+// even if dir is outside of the owner's home, without following the links
+// leaving dir. Browsers reference unpacked and component extensions by
+// absolute path, e.g. /opt/google/chrome/resources/pdf.
+func withExtensionDir(ctx context.Context, dir string) context.Context {
+	return context.WithValue(ctx, extensionDirKey{}, dir)
+}
+
+func extensionDirFromContext(ctx context.Context) (string, bool) {
+	dir, ok := ctx.Value(extensionDirKey{}).(string)
+	return dir, ok && dir != ""
+}
+
+type tableNameKey struct{}
+
+// WithTableName returns a context in which refusals are recorded for the
+// table name
+func WithTableName(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, tableNameKey{}, name)
+}
+
+func tableNameFromContext(ctx context.Context) string {
+	name, _ := ctx.Value(tableNameKey{}).(string)
+	return name
+}
+
+// refuse records the refusal to read path for the table and owner of the
+// context and returns it
+func refuse(ctx context.Context, op, path string, err error) error {
+	owner, _ := ownerFromContext(ctx)
+	refused := &refusedError{err: &fs.PathError{Op: op, Path: path, Err: err}}
+	recordError(tableNameFromContext(ctx), owner.Name, path, refused)
+	return refused
+}
+
+// isWithin reports whether path is dir or below it
+func isWithin(dir, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// openInHome opens path, which must be in home, without following the links
+// leaving home. The home directory may be reached through a link, e.g.
+// /home to /var/home, so path can be below the resolved home too. Below a
+// root directory the links are resolved relative to it.
+func openInHome(fsys FileSystem, home, path string) (fs.File, error) {
+	homes := []string{home}
+	if resolved, err := evalSymlinks(fsys, GetRootDir(), home); err == nil && resolved != home {
+		homes = append(homes, resolved)
+	}
+	for _, dir := range homes {
+		if !isWithin(dir, path) {
+			continue
+		}
+		return openInDir(fsys, dir, path)
+	}
+	return nil, ErrOutsideHome
+}
+
+// openInDir opens path, which must be in dir, without following the links
+// leaving dir
+func openInDir(fsys FileSystem, dir, path string) (fs.File, error) {
+	rel, err := filepath.Rel(dir, path)
+	if err != nil {
+		return nil, ErrOutsideHome
+	}
+	file, err := fsys.OpenInDir(dir, rel)
+	if errors.Is(err, errOutsideDir) {
+		return nil, ErrOutsideHome
+	}
+	return file, err
+}
+
+// CheckOwner returns an error wrapping ErrNotOwned when path is outside of the
+// home directory of account and is owned by another user. Paths in the home
+// directory are confined to it when read instead. Filesystems that don't
+// report owners, e.g. on Windows, pass the check.
+func CheckOwner(ctx context.Context, account UserAccount, path string) error {
+	return checkOwner(ctx, account, path, false)
+}
+
+func checkOwner(ctx context.Context, account UserAccount, path string, allowRoot bool) error {
+	if account.Home != "" && isWithin(account.Home, path) {
+		return nil
+	}
+	info, err := FileSystemFromContext(ctx).Stat(path)
+	if err != nil {
+		return err
+	}
+	uid := ownerUID(info)
+	if uid == "" || uid == account.UID || (allowRoot && uid == "0") {
+		return nil
+	}
+	return &fs.PathError{Op: "stat", Path: path, Err: ErrNotOwned}
+}
+
+// SafeOpen opens a file on the filesystem of the context for a table to read
+// it. Files of the owner set with WithOwner must be in the owner's home
+// directory and are opened without following the links leaving it, so a
+// directory swapped for a link after the profiles were discovered can't
+// redirect the read. Only regular files under the maximum size are opened.
+// Refusals are recorded for the table of the context.
+func SafeOpen(ctx context.Context, path string) (fs.File, error) {
+	fsys := FileSystemFromContext(ctx)
+
+	var file fs.File
+	var err error
+	if dir, ok := extensionDirFromContext(ctx); ok && isWithin(dir, path) {
+		file, err = openInDir(fsys, dir, path)
+	} else if owner, ok := ownerFromContext(ctx); ok {
+		file, err = openInHome(fsys, owner.Home, path)
+	} else {
+		file, err = fsys.Open(path)
+	}
+	if errors.Is(err, ErrOutsideHome) {
+		return nil, refuse(ctx, "open", path, ErrOutsideHome)
+	}
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	if !info.Mode().IsRegular() {
+		file.Close()
+		return nil, refuse(ctx, "open", path, ErrNotRegularFile)
+	}
+	if limit := GetMaxFileSize(); limit > 0 && info.Size() > limit {
+		file.Close()
+		return nil, refuse(ctx, "open", path, ErrFileTooLarge)
+	}
+	return file, nil
+}
+
+// readLimited reads a file opened with SafeOpen, which may have grown past
+// the maximum size since it was opened
+func readLimited(ctx context.Context, path string, file io.Reader) ([]byte, error) {
+	limit := GetMaxFileSize()
+	if limit <= 0 {
+		return io.ReadAll(file)
+	}
+	data, err := io.ReadAll(io.LimitReader(file, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > limit {
+		return nil, refuse(ctx, "read", path, ErrFileTooLarge)
+	}
+	return data, nil
+}
@@ -0,0 +1,164 @@
+package utils
+
+import (
+	"context"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"testing/fstest"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// refusalsSince returns the errors recorded for the table after the first
+// before ones
+func refusalsSince(before int, tableName string) []TableError {
+	var refusals []TableError
+	for _, e := range RecentErrors()[before:] {
+		if e.Table == tableName {
+			refusals = append(refusals, e)
+		}
+	}
+	return refusals
+}
+
+func TestSafeOpenOutsideHome(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("creating symlinks requires privileges on Windows")
+	}
+	home := t.TempDir()
+	secret := filepath.Join(t.TempDir(), "shadow")
+	require.NoError(t, os.WriteFile(secret, []byte("root:!:19000::::::"), 0600))
+	profileDir := filepath.Join(home, ".config", "google-chrome", "Default")
+	require.NoError(t, os.MkdirAll(profileDir, 0700))
+	require.NoError(t, os.WriteFile(filepath.Join(profileDir, SecureProfilePreferencesFile), []byte("{}"), 0600))
+	require.NoError(t, os.Symlink(secret, filepath.Join(profileDir, ProfilePreferencesFile)))
+	// Relative links staying in the home directory are followed, absolute
+	// ones aren't
+	require.NoError(t, os.Symlink(SecureProfilePreferencesFile, filepath.Join(profileDir, "Linked")))
+	require.NoError(t, os.Symlink(filepath.Join(profileDir, SecureProfilePreferencesFile), filepath.Join(profileDir, "Absolute")))
+
+	before := len(RecentErrors())
+	ctx := WithTableName(WithOwner(context.Background(), UserAccount{Name: "alice", UID: "1000", Home: home}), "test_safe_open")
+
+	_, err := ReadFile(ctx, filepath.Join(profileDir, ProfilePreferencesFile))
+	assert.ErrorIs(t, err, ErrOutsideHome)
+	assert.Equal(t, ErrorClassRefused, ClassifyError(err))
+
+	data, err := ReadFile(ctx, filepath.Join(profileDir, "Linked"))
+	require.NoError(t, err)
+	assert.Equal(t, "{}", string(data))
+
+	_, err = ReadFile(ctx, filepath.Join(profileDir, "Absolute"))
+	assert.ErrorIs(t, err, ErrOutsideHome)
+
+	// The refusal is recorded once, even if the caller records it too
+	RecordError("test_safe_open", "alice", profileDir, errors.Wrap(err, "reading preferences"))
+	refusals := refusalsSince(before, "test_safe_open")
+	require.Len(t, refusals, 2)
+	assert.Equal(t, "alice", refusals[0].User)
+	assert.Equal(t, filepath.Join(profileDir, ProfilePreferencesFile), refusals[0].Path)
+	assert.Equal(t, ErrorClassRefused, refusals[0].Class)
+
+	// Files without an owner, e.g. given explicitly, aren't confined
+	data, err = ReadFile(WithTableName(context.Background(), "test_safe_open"), filepath.Join(profileDir, ProfilePreferencesFile))
+	require.NoError(t, err)
+	assert.Equal(t, "root:!:19000::::::", string(data))
+}
+
+// swapOnOpen runs swap right before a file is opened, after the checks of
+// SafeOpen
+type swapOnOpen struct {
+	FileSystem
+	swap func()
+}
+
+func (f swapOnOpen) Open(name string) (fs.File, error) {
+	f.swap()
+	return f.FileSystem.Open(name)
+}
+
+func (f swapOnOpen) OpenInDir(dir, name string) (fs.File, error) {
+	f.swap()
+	return f.FileSystem.OpenInDir(dir, name)
+}
+
+func TestSafeOpenSwappedDirectory(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("creating symlinks requires privileges on Windows")
+	}
+	home := t.TempDir()
+	outside := t.TempDir()
+	userDataDir := filepath.Join(home, ".config", "google-chrome")
+	for _, dir := range []string{userDataDir, outside} {
+		require.NoError(t, os.MkdirAll(filepath.Join(dir, "Default"), 0700))
+	}
+	preferencesFile := filepath.Join(userDataDir, "Default", ProfilePreferencesFile)
+	require.NoError(t, os.WriteFile(preferencesFile, []byte("{}"), 0600))
+	require.NoError(t, os.WriteFile(filepath.Join(outside, "Default", ProfilePreferencesFile), []byte(`{"secret": true}`), 0600))
+	owner := UserAccount{Name: "alice", Home: home}
+
+	// An absolute link and a relative one leaving the home directory
+	relTarget, err := filepath.Rel(filepath.Dir(userDataDir), outside)
+	require.NoError(t, err)
+	for _, target := range []string{outside, relTarget} {
+		var swapped bool
+		fsys := swapOnOpen{FileSystem: OSFileSystem, swap: func() {
+			if swapped {
+				return
+			}
+			swapped = true
+			require.NoError(t, os.Rename(userDataDir, userDataDir+".old"))
+			require.NoError(t, os.Symlink(target, userDataDir))
+		}}
+		ctx := WithOwner(WithFileSystem(context.Background(), fsys), owner)
+
+		data, err := ReadFile(ctx, preferencesFile)
+		assert.True(t, swapped)
+		assert.ErrorIs(t, err, ErrOutsideHome, target)
+		assert.Empty(t, data)
+
+		require.NoError(t, os.Remove(userDataDir))
+		require.NoError(t, os.Rename(userDataDir+".old", userDataDir))
+	}
+}
+
+func TestSafeOpenMaxFileSize(t *testing.T) {
+	SetMaxFileSize(4)
+	t.Cleanup(func() { SetMaxFileSize(DefaultMaxFileSize) })
+
+	ctx := WithTableName(WithFileSystem(context.Background(), NewFileSystem(fstest.MapFS{
+		"home/alice/small.json": {Data: []byte("{}")},
+		"home/alice/large.json": {Data: []byte(`{"a": 1}`)},
+	})), "test_max_file_size")
+	before := len(RecentErrors())
+
+	data, err := ReadFile(ctx, filepath.FromSlash("/home/alice/small.json"))
+	require.NoError(t, err)
+	assert.Equal(t, "{}", string(data))
+
+	_, err = ReadFile(ctx, filepath.FromSlash("/home/alice/large.json"))
+	assert.ErrorIs(t, err, ErrFileTooLarge)
+	refusals := refusalsSince(before, "test_max_file_size")
+	require.Len(t, refusals, 1)
+	assert.Equal(t, ErrorClassTooLarge, refusals[0].Class)
+
+	// No limit
+	SetMaxFileSize(0)
+	_, err = ReadFile(ctx, filepath.FromSlash("/home/alice/large.json"))
+	assert.NoError(t, err)
+}
+
+func TestSafeOpenNotRegularFile(t *testing.T) {
+	ctx := WithFileSystem(context.Background(), NewFileSystem(fstest.MapFS{
+		"home/alice/.config/google-chrome/Default/Preferences/x": {Data: []byte("{}")},
+	}))
+
+	_, err := ReadFile(ctx, filepath.FromSlash("/home/alice/.config/google-chrome/Default/Preferences"))
+	assert.ErrorIs(t, err, ErrNotRegularFile)
+	assert.Equal(t, ErrorClassRefused, ClassifyError(err))
+}
@@ -0,0 +1,68 @@
+//go:build !windows
+// +build !windows
+
+package utils
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSafeOpenFIFO(t *testing.T) {
+	home := t.TempDir()
+	fifo := filepath.Join(home, ProfilePreferencesFile)
+	require.NoError(t, syscall.Mkfifo(fifo, 0600))
+
+	// Opening the FIFO must not wait for a writer
+	ctx := WithOwner(context.Background(), UserAccount{Name: "alice", Home: home})
+	_, err := ReadFile(ctx, fifo)
+	assert.ErrorIs(t, err, ErrNotRegularFile)
+}
+
+func TestExtensionOutsideHome(t *testing.T) {
+	extDir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(extDir, "manifest.json"), []byte(`{"name": "Unpacked"}`), 0600))
+	// A link to a file outside of the extension directory isn't followed
+	linkedDir := t.TempDir()
+	secret := filepath.Join(t.TempDir(), "secret.json")
+	require.NoError(t, os.WriteFile(secret, []byte(`{"name": "Secret"}`), 0600))
+	require.NoError(t, os.Symlink(secret, filepath.Join(linkedDir, "manifest.json")))
+
+	account := UserAccount{Name: "alice", UID: strconv.Itoa(os.Getuid()), Home: t.TempDir()}
+	ctx := WithTableName(WithOwner(context.Background(), account), "test_extension_outside_home")
+	profilePath := filepath.Join(account.Home, "Default")
+	embedded := &ChromeExtensionManifest{Name: "Embedded"}
+
+	extension := newChromeExtension(ctx, profilePath, "abc", &ChromeExtensionSettings{Path: extDir, Manifest: embedded})
+	assert.Equal(t, "Unpacked", extension.Name)
+	extension = newChromeExtension(ctx, profilePath, "abc", &ChromeExtensionSettings{Path: linkedDir, Manifest: embedded})
+	assert.Equal(t, "Embedded", extension.Name)
+	// A missing directory falls back to the embedded manifest silently
+	before := len(RecentErrors())
+	extension = newChromeExtension(ctx, profilePath, "abc", &ChromeExtensionSettings{Path: filepath.Join(extDir, "missing"), Manifest: embedded})
+	assert.Equal(t, "Embedded", extension.Name)
+	assert.Empty(t, refusalsSince(before, "test_extension_outside_home"))
+
+	if os.Getuid() != 0 {
+		t.Skip("changing the owner requires root")
+	}
+	// Directories owned by root are read, the ones of another user aren't
+	account.UID = "1000"
+	ctx = WithTableName(WithOwner(context.Background(), account), "test_extension_outside_home")
+	extension = newChromeExtension(ctx, profilePath, "abc", &ChromeExtensionSettings{Path: extDir, Manifest: embedded})
+	assert.Equal(t, "Unpacked", extension.Name)
+	require.NoError(t, os.Chown(extDir, 4242, 4242))
+	extension = newChromeExtension(ctx, profilePath, "abc", &ChromeExtensionSettings{Path: extDir, Manifest: embedded})
+	assert.Equal(t, "Embedded", extension.Name)
+	refusals := refusalsSince(before, "test_extension_outside_home")
+	require.Len(t, refusals, 1)
+	assert.Equal(t, ErrorClassRefused, refusals[0].Class)
+	assert.Equal(t, extDir, refusals[0].Path)
+}
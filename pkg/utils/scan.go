@@ -0,0 +1,100 @@
+package utils
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+)
+
+// maxScanWorkers bounds the number of profiles parsed at the same time
+const maxScanWorkers = 8
+
+type scanResult struct {
+	index int
+	rows  []map[string]string
+	err   error
+}
+
+// ScanChromeProfiles parses the profiles with a bounded pool of workers and
+// returns their rows in the order of the profiles. Parse errors are recorded
+// for tableName. Once ctx is done the rows of the profiles parsed so far are
+// returned without waiting for the workers, which may be stuck on a slow
+// file, and a timeout error is recorded for every other profile.
+func ScanChromeProfiles(ctx context.Context, tableName string, profiles []ChromeProfilePath, parse func(context.Context, ChromeProfilePath) ([]map[string]string, error)) []map[string]string {
+	// Buffered so late workers don't block once we stopped waiting
+	results := make(chan scanResult, len(profiles))
+	jobs := make(chan int)
+
+	workers := maxScanWorkers
+	if len(profiles) < workers {
+		workers = len(profiles)
+	}
+	for i := 0; i < workers; i++ {
+		go func() {
+			for index := range jobs {
+				profile := profiles[index]
+				// The files of a profile must stay in the home directory
+				// of its user
+				profileCtx := WithOwner(WithTableName(ctx, tableName), profile.Owner())
+				rows, err := parse(profileCtx, profile)
+				results <- scanResult{index: index, rows: rows, err: err}
+			}
+		}()
+	}
+	go func() {
+		defer close(jobs)
+		for index := range profiles {
+			select {
+			case jobs <- index:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	rows := make([][]map[string]string, len(profiles))
+	scanned := make([]bool, len(profiles))
+	handle := func(result scanResult) {
+		profile := profiles[result.index]
+		if result.err != nil {
+			RecordError(tableName, profile.UserName, profile.Value, result.err)
+		}
+		rows[result.index] = result.rows
+		scanned[result.index] = true
+	}
+
+	remaining := len(profiles)
+wait:
+	for remaining > 0 {
+		select {
+		case result := <-results:
+			handle(result)
+			remaining--
+		case <-ctx.Done():
+			break wait
+		}
+	}
+	// Keep the profiles that finished along with the deadline
+	for drained := false; remaining > 0 && !drained; {
+		select {
+		case result := <-results:
+			handle(result)
+			remaining--
+		default:
+			drained = true
+		}
+	}
+
+	var output []map[string]string
+	for index, profile := range profiles {
+		if !scanned[index] {
+			// The caller going away isn't an error of the table
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				RecordError(tableName, profile.UserName, profile.Value, errors.Wrap(ctx.Err(), "profile not scanned before the deadline"))
+			}
+			continue
+		}
+		output = append(output, rows[index]...)
+	}
+	return output
+}
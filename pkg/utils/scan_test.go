@@ -0,0 +1,90 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func scanProfiles(n int) []ChromeProfilePath {
+	var profiles []ChromeProfilePath
+	for i := 0; i < n; i++ {
+		profiles = append(profiles, ChromeProfilePath{UserName: "scan_user", Value: fmt.Sprintf("/scan/Profile %d", i)})
+	}
+	return profiles
+}
+
+func TestScanChromeProfiles(t *testing.T) {
+	profiles := scanProfiles(20)
+	parse := func(ctx context.Context, profile ChromeProfilePath) ([]map[string]string, error) {
+		if profile.Value == "/scan/Profile 3" {
+			return nil, fmt.Errorf("broken profile")
+		}
+		return []map[string]string{{"profile": profile.Value}}, nil
+	}
+
+	rows := ScanChromeProfiles(context.Background(), "test_scan", profiles, parse)
+	// Rows keep the order of the profiles
+	var expected []map[string]string
+	for i, profile := range profiles {
+		if i != 3 {
+			expected = append(expected, map[string]string{"profile": profile.Value})
+		}
+	}
+	assert.Equal(t, expected, rows)
+
+	errs := RecentErrors()
+	last := errs[len(errs)-1]
+	assert.Equal(t, "test_scan", last.Table)
+	assert.Equal(t, "/scan/Profile 3", last.Path)
+}
+
+func TestScanChromeProfilesDeadline(t *testing.T) {
+	profiles := scanProfiles(maxScanWorkers + 2)
+	// The first profile sits on a home that never answers
+	stuck := make(chan struct{})
+	defer close(stuck)
+	parse := func(ctx context.Context, profile ChromeProfilePath) ([]map[string]string, error) {
+		if profile.Value == "/scan/Profile 0" {
+			<-stuck
+		}
+		return []map[string]string{{"profile": profile.Value}}, nil
+	}
+
+	before := len(RecentErrors())
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	rows := ScanChromeProfiles(ctx, "test_scan_deadline", profiles, parse)
+	assert.Less(t, time.Since(start), 2*time.Second)
+	// Every other profile was parsed before the deadline
+	assert.Len(t, rows, len(profiles)-1)
+
+	var timeouts []string
+	for _, e := range RecentErrors()[before:] {
+		if e.Table == "test_scan_deadline" {
+			assert.Equal(t, ErrorClassTimeout, e.Class)
+			timeouts = append(timeouts, e.Path)
+		}
+	}
+	assert.Equal(t, []string{"/scan/Profile 0"}, timeouts)
+}
+
+func TestScanChromeProfilesCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	parse := func(ctx context.Context, profile ChromeProfilePath) ([]map[string]string, error) {
+		return nil, ctx.Err()
+	}
+
+	before := len(RecentErrors())
+	ScanChromeProfiles(ctx, "test_scan_cancelled", scanProfiles(3), parse)
+	// Profiles cancelled by the caller aren't reported as timeouts
+	for _, e := range RecentErrors()[before:] {
+		assert.NotEqual(t, ErrorClassTimeout, e.Class, strconv.Quote(e.Path))
+	}
+}
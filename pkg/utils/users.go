@@ -0,0 +1,236 @@
+package utils
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"log"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// UserAccount is a local account and its home directory. Home is prefixed
+// with the root directory.
+type UserAccount struct {
+	Name string
+	UID  string
+	Home string
+}
+
+// passwdFile is the account database read on unix platforms
+var passwdFile = "/etc/passwd"
+
+// usersQuery reads the accounts from osquery's users table
+const usersQuery = "SELECT username, uid, directory FROM users"
+
+// usersCacheTTL limits how often the osquery users table is queried
+const usersCacheTTL = time.Minute
+
+var (
+	usersMu         sync.Mutex
+	osqueryClienter OsqueryClienter
+	cachedUsers     []UserAccount
+	cachedUsersTime time.Time
+)
+
+// SetOsqueryClienter sets the client used to resolve accounts from osquery's
+// users table. Without it accounts are read from /etc/passwd and the home
+// directory roots only.
+func SetOsqueryClienter(clienter OsqueryClienter) {
+	usersMu.Lock()
+	defer usersMu.Unlock()
+	osqueryClienter = clienter
+	cachedUsers = nil
+}
+
+// parsePasswd parses an /etc/passwd file
+func parsePasswd(data []byte) []UserAccount {
+	var accounts []UserAccount
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		// name:password:uid:gid:gecos:home:shell
+		fields := strings.Split(line, ":")
+		if len(fields) < 7 {
+			continue
+		}
+		accounts = append(accounts, UserAccount{Name: fields[0], UID: fields[2], Home: fields[5]})
+	}
+	return accounts
+}
+
+// osqueryUsers returns the accounts of osquery's users table, nil when no
+// client is set or the query fails
+func osqueryUsers() []UserAccount {
+	usersMu.Lock()
+	defer usersMu.Unlock()
+	if osqueryClienter == nil {
+		return nil
+	}
+	if cachedUsers != nil && time.Since(cachedUsersTime) < usersCacheTTL {
+		return cachedUsers
+	}
+
+	client, err := osqueryClienter.NewOsqueryClient()
+	if err != nil {
+		log.Printf("Error creating osquery client: %s", err)
+		return nil
+	}
+	defer client.Close()
+	rows, err := client.QueryRows(usersQuery)
+	if err != nil {
+		log.Printf("Error querying users: %s", err)
+		return nil
+	}
+
+	accounts := make([]UserAccount, 0, len(rows))
+	for _, row := range rows {
+		accounts = append(accounts, UserAccount{Name: row["username"], UID: row["uid"], Home: row["directory"]})
+	}
+	cachedUsers, cachedUsersTime = accounts, time.Now()
+	return accounts
+}
+
+// resolveInRoot resolves the links of a path of the image below root, see
+// evalSymlinks. Without a root the path is returned as is.
+func resolveInRoot(fsys FileSystem, root, path string) (string, error) {
+	if root == "" {
+		return path, nil
+	}
+	return evalSymlinks(fsys, root, path)
+}
+
+// scanHomeDirRoots lists the directories in the home directory roots as
+// accounts. A root that can't be read is skipped. When usernames is set only
+// the directories of these names are looked up, the others aren't touched.
+func scanHomeDirRoots(fsys FileSystem, root string, usernames map[string]bool) []UserAccount {
+	var accounts []UserAccount
+	homedirRoots, ok := HomeDirLocations[runtime.GOOS]
+	if !ok {
+		homedirRoots = homeDirDefaultLocation
+	}
+	for _, homedirRoot := range homedirRoots {
+		homedirRoot, err := resolveInRoot(fsys, root, rootPathWith(root, homedirRoot))
+		if err != nil {
+			continue
+		}
+		var names []string
+		if len(usernames) > 0 {
+			for name := range usernames {
+				// A name from a query constraint must not leave the root
+				if filepath.IsLocal(name) && filepath.Base(name) == name {
+					names = append(names, name)
+				}
+			}
+		} else {
+			userDirs, err := fsys.ReadDir(homedirRoot)
+			if err != nil {
+				continue
+			}
+			for _, userDir := range userDirs {
+				if userDir.IsDir() {
+					names = append(names, userDir.Name())
+				}
+			}
+		}
+		for _, name := range names {
+			home := filepath.Join(homedirRoot, name)
+			uid := ""
+			if resolved, err := resolveInRoot(fsys, root, home); err == nil {
+				if info, err := fsys.Stat(resolved); err == nil {
+					uid = ownerUID(info)
+				}
+			}
+			accounts = append(accounts, UserAccount{Name: name, UID: uid, Home: home})
+		}
+	}
+	return accounts
+}
+
+// listUserAccounts resolves the accounts with a home directory. Accounts come
+// from osquery's users table when available, /etc/passwd, and the
+// directories of the home directory roots not claimed by a known account.
+// osquery's users table describes the live system, it is not used when root is
+// set or the context has another filesystem than the host's. When usernames
+// is set the homes of the other accounts are never looked at.
+func listUserAccounts(ctx context.Context, root string, usernames map[string]bool) []UserAccount {
+	fsys := FileSystemFromContext(ctx)
+	var candidates []UserAccount
+	if root == "" && fsys == OSFileSystem {
+		candidates = append(candidates, osqueryUsers()...)
+	}
+	if runtime.GOOS != "windows" {
+		if path, err := resolveInRoot(fsys, root, rootPathWith(root, passwdFile)); err == nil {
+			if data, err := fsys.ReadFile(path); err == nil {
+				candidates = append(candidates, parsePasswd(data)...)
+			}
+		}
+	}
+	for i := range candidates {
+		candidates[i].Home = rootPathWith(root, candidates[i].Home)
+	}
+	// Already prefixed with the root directory
+	candidates = append(candidates, scanHomeDirRoots(fsys, root, usernames)...)
+
+	var accounts []UserAccount
+	seenUsers := map[string]bool{}
+	seenHomes := map[string]bool{}
+	for _, account := range candidates {
+		home, err := resolveInRoot(fsys, root, filepath.Clean(account.Home))
+		if err != nil {
+			continue
+		}
+		// System accounts share directories such as / or /var/empty
+		if account.Name == "" || seenUsers[account.Name] || seenHomes[home] || home == filepath.Clean(rootPathWith(root, "/")) {
+			continue
+		}
+		// Still claims its name and home, as without the filter
+		if len(usernames) > 0 && !usernames[account.Name] {
+			seenUsers[account.Name] = true
+			seenHomes[home] = true
+			continue
+		}
+		if stat, err := fsys.Stat(home); err != nil || !stat.IsDir() {
+			continue
+		}
+		seenUsers[account.Name] = true
+		seenHomes[home] = true
+		account.Home = home
+		accounts = append(accounts, account)
+	}
+	sort.Slice(accounts, func(i, j int) bool { return accounts[i].Name < accounts[j].Name })
+	return accounts
+}
+
+// ListUserAccounts returns the local accounts with an existing home
+// directory, below the root directory if one is set, on the filesystem of the
+// context
+func ListUserAccounts(ctx context.Context) []UserAccount {
+	return listUserAccounts(ctx, GetRootDir(), nil)
+}
+
+// UserFromPath returns the account whose home directory contains path and
+// the path relative to it
+func UserFromPath(ctx context.Context, path string) (UserAccount, string, bool) {
+	var best UserAccount
+	var bestRel string
+	found := false
+	for _, account := range ListUserAccounts(ctx) {
+		rel, err := filepath.Rel(account.Home, path)
+		if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			continue
+		}
+		// Prefer the deepest home, e.g. /home/alice/shared over /home/alice
+		if !found || len(account.Home) > len(best.Home) {
+			best, bestRel, found = account, rel, true
+		}
+	}
+	return best, bestRel, found
+}
@@ -0,0 +1,70 @@
+package utils
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testPasswd = `# Accounts of the fixture
+root:x:0:0:root:/root:/bin/bash
+daemon:x:1:1:daemon:/:/usr/sbin/nologin
+alice:x:1000:1000:Alice:/home/alice:/bin/bash
+svc:x:998:998:Service:/var/lib/svc:/bin/sh
+bob:x:1001:1001:Bob:/home/bob:/bin/bash
+shared:x:999:999:Shares alice's home:/home/alice:/bin/sh
+broken line
+`
+
+func TestListUserAccounts(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("accounts are read from osquery on Windows")
+	}
+
+	root := t.TempDir()
+	for _, dir := range []string{"root", "home/alice", "home/carol", "var/lib/svc", "etc"} {
+		require.NoError(t, os.MkdirAll(filepath.Join(root, dir), 0755))
+	}
+	require.NoError(t, os.WriteFile(filepath.Join(root, "etc", "passwd"), []byte(testPasswd), 0644))
+
+	homes := HomeDirLocations[runtime.GOOS]
+	HomeDirLocations[runtime.GOOS] = []string{"/home"}
+	t.Cleanup(func() { HomeDirLocations[runtime.GOOS] = homes })
+
+	// bob's home doesn't exist, daemon's is / and shared's is already
+	// alice's. carol is only known from her home directory.
+	assert.Equal(t, []UserAccount{
+		{Name: "alice", UID: "1000", Home: filepath.Join(root, "home", "alice")},
+		{Name: "carol", UID: dirOwnerUID(t, filepath.Join(root, "home", "carol")), Home: filepath.Join(root, "home", "carol")},
+		{Name: "root", UID: "0", Home: filepath.Join(root, "root")},
+		{Name: "svc", UID: "998", Home: filepath.Join(root, "var", "lib", "svc")},
+	}, listUserAccounts(context.Background(), root, nil))
+}
+
+func TestOsqueryUsers(t *testing.T) {
+	SetOsqueryClienter(&MockOsqueryClienter{
+		Data: map[string][]map[string]string{
+			usersQuery: {{"username": "alice", "uid": "501", "directory": "/Users/alice"}},
+		},
+	})
+	t.Cleanup(func() { SetOsqueryClienter(nil) })
+
+	assert.Equal(t, []UserAccount{{Name: "alice", UID: "501", Home: "/Users/alice"}}, osqueryUsers())
+}
+
+func TestUserFromPath(t *testing.T) {
+	homeRoot := setupHomeDirs(t)
+
+	account, rel, found := UserFromPath(context.Background(), filepath.Join(homeRoot, "alice", ".vscode", "extensions"))
+	assert.True(t, found)
+	assert.Equal(t, "alice", account.Name)
+	assert.Equal(t, filepath.Join(".vscode", "extensions"), rel)
+
+	_, _, found = UserFromPath(context.Background(), filepath.Join(t.TempDir(), "case42"))
+	assert.False(t, found)
+}
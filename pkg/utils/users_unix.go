@@ -0,0 +1,21 @@
+//go:build !windows
+// +build !windows
+
+package utils
+
+import (
+	"io/fs"
+	"strconv"
+	"syscall"
+)
+
+// ownerUID returns the uid of the owner of a file, used for home directories
+// of accounts that aren't in the account database. It is empty when the
+// filesystem doesn't report owners.
+func ownerUID(info fs.FileInfo) string {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return ""
+	}
+	return strconv.FormatUint(uint64(stat.Uid), 10)
+}
@@ -0,0 +1,12 @@
+//go:build windows
+// +build windows
+
+package utils
+
+import "io/fs"
+
+// ownerUID is not available on Windows, accounts are resolved through
+// osquery's users table instead
+func ownerUID(info fs.FileInfo) string {
+	return ""
+}
@@ -1,10 +1,13 @@
 package utils
 
 import (
+	"context"
+	"io/fs"
 	"log"
-	"os"
 	"path/filepath"
-	"runtime"
+	"strings"
+
+	"github.com/pkg/errors"
 )
 
 type findFile struct {
@@ -26,72 +29,141 @@ var HomeDirLocations = map[string][]string{
 }
 var homeDirDefaultLocation = []string{"/home"}
 
+// rootDir is prefixed to every absolute path the tables look at. It allows
+// running the tables against a mounted disk image or an extracted triage
+// archive instead of the live system.
+var rootDir string
+
+// SetRootDir sets the directory prefixed to every absolute path.
+func SetRootDir(root string) {
+	rootDir = root
+}
+
+// GetRootDir returns the directory prefixed to every absolute path. It is
+// empty when the tables run against the live system.
+func GetRootDir() string {
+	return rootDir
+}
+
+// RootPath prefixes an absolute path with the root directory, if any.
+func RootPath(path string) string {
+	return rootPathWith(rootDir, path)
+}
+
+func rootPathWith(root, path string) string {
+	if root == "" {
+		return path
+	}
+	// Drop the volume name so Windows paths can be joined too
+	return filepath.Join(root, strings.TrimPrefix(path, filepath.VolumeName(path)))
+}
+
+// maxLinks bounds the links followed resolving a path, as the kernel does
+const maxLinks = 40
+
+// errOutsideRoot is returned for the links of an image leaving the root
+// directory
+var errOutsideRoot = errors.New("path resolves outside of the root directory")
+
+// evalSymlinks resolves the links of path. Below a root directory the links
+// are those of the image, they are resolved relative to it: absolute targets
+// are taken below root and targets leaving root are refused, rather than
+// resolved against the live system.
+func evalSymlinks(fsys FileSystem, root, path string) (string, error) {
+	if root == "" {
+		return fsys.EvalSymlinks(path)
+	}
+	root = filepath.Clean(root)
+	if !isWithin(root, path) {
+		return "", &fs.PathError{Op: "lstat", Path: path, Err: errOutsideRoot}
+	}
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		return "", err
+	}
+
+	resolved := root
+	pending := strings.Split(rel, string(filepath.Separator))
+	links := 0
+	for len(pending) > 0 {
+		name := pending[0]
+		pending = pending[1:]
+		switch name {
+		case "", ".":
+			continue
+		case "..":
+			if resolved == root {
+				return "", &fs.PathError{Op: "lstat", Path: path, Err: errOutsideRoot}
+			}
+			resolved = filepath.Dir(resolved)
+			continue
+		}
+
+		next := filepath.Join(resolved, name)
+		info, err := fsys.Lstat(next)
+		if err != nil {
+			return "", err
+		}
+		if info.Mode()&fs.ModeSymlink == 0 {
+			resolved = next
+			continue
+		}
+		if links++; links > maxLinks {
+			return "", &fs.PathError{Op: "lstat", Path: path, Err: errors.New("too many links")}
+		}
+		target, err := fsys.Readlink(next)
+		if err != nil {
+			return "", err
+		}
+		if volume := filepath.VolumeName(target); volume != "" || strings.HasPrefix(target, string(filepath.Separator)) {
+			// An absolute target is a path of the image
+			target = strings.TrimPrefix(target, volume)
+			resolved = root
+		}
+		pending = append(strings.Split(target, string(filepath.Separator)), pending...)
+	}
+	return resolved, nil
+}
+
 type UserFileInfo struct {
 	User string
+	UID  string
+	Home string
 	Path string
 }
 
 // findFileInUserDirs looks for the existence of a specified path as a
 // subdirectory of users' home directories. It does this by searching
-// likely paths
-func FindFileInUserDirs(pattern string, opts ...FindFileOpt) ([]UserFileInfo, error) {
+// likely paths on the filesystem of the context
+func FindFileInUserDirs(ctx context.Context, pattern string, opts ...FindFileOpt) ([]UserFileInfo, error) {
 	ff := &findFile{}
 
 	for _, opt := range opts {
 		opt(ff)
 	}
 
-	homedirRoots, ok := HomeDirLocations[runtime.GOOS]
-	if !ok {
-		homedirRoots = homeDirDefaultLocation
-		log.Printf("Platform not found using default home_dir_root: %s", homedirRoots)
-	}
-
+	fsys := FileSystemFromContext(ctx)
 	foundPaths := []UserFileInfo{}
 
-	if ff.username == "" {
-		for _, possibleHome := range homedirRoots {
-
-			userDirs, err := os.ReadDir(possibleHome)
-			if err != nil {
-				// This possibleHome doesn't exist. Move on
-				continue
-			}
-
-			// For each user's dir, in this possibleHome, check
-			for _, ud := range userDirs {
-				userPathPattern := filepath.Join(possibleHome, ud.Name(), pattern)
-				fullPaths, err := filepath.Glob(userPathPattern)
-				if err != nil {
-					// skipping ErrBadPattern
-					log.Printf("Bad file pattern %s", userPathPattern)
-					continue
-				}
-				// If the found path is a file, add it to the list
-				for _, fullPath := range fullPaths {
-					if stat, err := os.Stat(fullPath); err == nil && stat.Mode().IsRegular() {
-						foundPaths = append(foundPaths, UserFileInfo{
-							User: ud.Name(),
-							Path: fullPath,
-						})
-					}
-				}
-			}
+	for _, account := range ListUserAccounts(ctx) {
+		if ff.username != "" && account.Name != ff.username {
+			continue
 		}
-		return foundPaths, nil
-	}
 
-	// We have a username. Future normal path here
-	for _, possibleHome := range homedirRoots {
-		userPathPattern := filepath.Join(possibleHome, ff.username, pattern)
-		fullPaths, err := filepath.Glob(userPathPattern)
+		userPathPattern := filepath.Join(account.Home, pattern)
+		fullPaths, err := fsys.Glob(userPathPattern)
 		if err != nil {
+			// skipping ErrBadPattern
+			log.Printf("Bad file pattern %s", userPathPattern)
 			continue
 		}
+		// If the found path is a file, add it to the list
 		for _, fullPath := range fullPaths {
-			if stat, err := os.Stat(fullPath); err == nil && stat.Mode().IsRegular() {
+			if stat, err := fsys.Stat(fullPath); err == nil && stat.Mode().IsRegular() {
 				foundPaths = append(foundPaths, UserFileInfo{
-					User: ff.username,
+					User: account.Name,
+					UID:  account.UID,
+					Home: account.Home,
 					Path: fullPath,
 				})
 			}
@@ -100,9 +172,11 @@ func FindFileInUserDirs(pattern string, opts ...FindFileOpt) ([]UserFileInfo, er
 	return foundPaths, nil
 }
 
-func FileExists(filename string) bool {
-	info, err := os.Stat(filename)
-	if os.IsNotExist(err) {
+// FileExists reports whether filename exists on the filesystem of the context
+// and isn't a directory
+func FileExists(ctx context.Context, filename string) bool {
+	info, err := FileSystemFromContext(ctx).Stat(filename)
+	if err != nil {
 		return false
 	}
 	return !info.IsDir()
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/nachorpaez/osquery-extensions/pkg/registry"
+	"github.com/nachorpaez/osquery-extensions/pkg/utils"
+	"github.com/osquery/osquery-go/plugin/table"
+	"github.com/pkg/errors"
+)
+
+const queryUsage = `Usage: osquery_extension query <table> [--format json|csv|table] [--where column=value]... [--root dir] [--table-timeout duration]
+
+Runs a table without osqueryd and prints its rows. --where can be repeated,
+values given for the same column are OR'ed and different columns are AND'ed.
+The errors the table ran into are printed on stderr, the exit code is 1 when
+the deadline cut the results short.
+`
+
+// whereFlags collects the repeated --where column=value flags
+type whereFlags map[string][]string
+
+func (w whereFlags) String() string {
+	var conditions []string
+	for column, values := range w {
+		for _, value := range values {
+			conditions = append(conditions, column+"="+value)
+		}
+	}
+	sort.Strings(conditions)
+	return strings.Join(conditions, ",")
+}
+
+func (w whereFlags) Set(condition string) error {
+	column, value, ok := strings.Cut(condition, "=")
+	if !ok || column == "" {
+		return errors.Errorf("expected column=value, got %q", condition)
+	}
+	w[column] = append(w[column], value)
+	return nil
+}
+
+// queryContext builds the constraints osquery would pass for
+// WHERE column = value [OR column = other_value ...]
+func (w whereFlags) queryContext() table.QueryContext {
+	queryContext := table.QueryContext{Constraints: map[string]table.ConstraintList{}}
+	for column, values := range w {
+		constraintList := table.ConstraintList{Affinity: table.ColumnTypeText}
+		for _, value := range values {
+			constraintList.Constraints = append(constraintList.Constraints, table.Constraint{
+				Operator:   table.OperatorEquals,
+				Expression: value,
+			})
+		}
+		queryContext.Constraints[column] = constraintList
+	}
+	return queryContext
+}
+
+// matches re-applies the constraints to a row, which osquery does after
+// calling the generate function
+func (w whereFlags) matches(row map[string]string) bool {
+	for column, values := range w {
+		found := false
+		for _, value := range values {
+			if row[column] == value {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func columnNames(columns []table.ColumnDefinition) []string {
+	names := make([]string, 0, len(columns))
+	for _, c := range columns {
+		names = append(names, c.Name)
+	}
+	return names
+}
+
+// outputFormats are the writers of the --format values
+var outputFormats = map[string]func(w io.Writer, columns []string, rows []map[string]string) error{
+	"json": func(w io.Writer, _ []string, rows []map[string]string) error {
+		return writeJSON(w, rows)
+	},
+	"csv":   writeCSV,
+	"table": writeTable,
+}
+
+func writeJSON(w io.Writer, rows []map[string]string) error {
+	if rows == nil {
+		rows = []map[string]string{}
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(rows)
+}
+
+func writeCSV(w io.Writer, columns []string, rows []map[string]string) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write(columns); err != nil {
+		return err
+	}
+	for _, row := range rows {
+		record := make([]string, 0, len(columns))
+		for _, c := range columns {
+			record = append(record, row[c])
+		}
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+func writeTable(w io.Writer, columns []string, rows []map[string]string) error {
+	writer := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(writer, strings.Join(columns, "\t"))
+	for _, row := range rows {
+		values := make([]string, 0, len(columns))
+		for _, c := range columns {
+			// Keep one row per line
+			values = append(values, strings.NewReplacer("\t", " ", "\n", " ").Replace(row[c]))
+		}
+		fmt.Fprintln(writer, strings.Join(values, "\t"))
+	}
+	return writer.Flush()
+}
+
+// runQuery implements the query subcommand and returns the exit code
+func runQuery(args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("query", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		fmt.Fprint(stderr, queryUsage)
+		flags.PrintDefaults()
+	}
+	where := whereFlags{}
+	format := flags.String("format", "table", "Output format: json, csv or table")
+	root := flags.String("root", "", "Directory prefixed to every path, e.g. a mounted disk image")
+	flags.Var(where, "where", "Constraint as column=value, can be repeated")
+	// Unlike osqueryd nothing waits on the results, so there is no deadline
+	// by default
+	timeout := flags.Duration("table-timeout", 0, "Deadline of the query, 0 disables it")
+
+	// Accept the table name before or after the flags
+	var tableName string
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		tableName, args = args[0], args[1:]
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if tableName == "" {
+		tableName = flags.Arg(0)
+	}
+	if tableName == "" {
+		flags.Usage()
+		fmt.Fprintf(stderr, "\nTables: %s\n", strings.Join(registry.Names(), ", "))
+		return 2
+	}
+
+	t, ok := registry.Lookup(tableName)
+	if !ok {
+		fmt.Fprintf(stderr, "Unknown table %q, available tables: %s\n", tableName, strings.Join(registry.Names(), ", "))
+		return 2
+	}
+
+	// Flags are checked before running the table, which can be slow
+	write, ok := outputFormats[*format]
+	if !ok {
+		fmt.Fprintf(stderr, "Unknown format %q, expected json, csv or table\n", *format)
+		return 2
+	}
+	columns := columnNames(t.Columns)
+	for column := range where {
+		if !contains(columns, column) {
+			fmt.Fprintf(stderr, "Unknown column %q for table %s\n", column, tableName)
+			return 2
+		}
+	}
+
+	utils.SetRootDir(*root)
+	registry.SetDefaultTimeout(*timeout)
+
+	start := time.Now()
+	rows, err := t.Generate(context.Background(), where.queryContext())
+	if err != nil {
+		fmt.Fprintf(stderr, "Error generating %s: %s\n", tableName, err)
+		return 1
+	}
+	var filtered []map[string]string
+	for _, row := range rows {
+		if where.matches(row) {
+			filtered = append(filtered, row)
+		}
+	}
+
+	if err := write(stdout, columns, filtered); err != nil {
+		fmt.Fprintf(stderr, "Error writing results: %s\n", err)
+		return 1
+	}
+
+	partial := false
+	for _, e := range utils.RecentErrors() {
+		if e.Table != tableName || e.Timestamp.Before(start) {
+			continue
+		}
+		fmt.Fprintf(stderr, "%s: %s (%s)\n", e.Path, e.Message, e.Class)
+		if e.Class == utils.ErrorClassTimeout {
+			partial = true
+		}
+	}
+	if partial {
+		fmt.Fprintf(stderr, "The deadline was reached, the results of %s are partial\n", tableName)
+		return 1
+	}
+	return 0
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/nachorpaez/osquery-extensions/pkg/registry"
+	"github.com/nachorpaez/osquery-extensions/pkg/utils"
+	"github.com/osquery/osquery-go/plugin/table"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writePreferences(t *testing.T) string {
+	content, err := os.ReadFile(filepath.Join("tables", "chrome_preferences", "test_Preferences"))
+	require.NoError(t, err)
+	preferencesFile := filepath.Join(t.TempDir(), "Preferences")
+	require.NoError(t, os.WriteFile(preferencesFile, content, 0600))
+	return preferencesFile
+}
+
+func TestRunQueryJSON(t *testing.T) {
+	preferencesFile := writePreferences(t)
+
+	var stdout, stderr bytes.Buffer
+	code := runQuery([]string{"chrome_preferences", "--format", "json", "--where", "path=" + preferencesFile, "--where", "category=geolocation"}, &stdout, &stderr)
+	require.Equal(t, 0, code, stderr.String())
+
+	var rows []map[string]string
+	require.NoError(t, json.Unmarshal(stdout.Bytes(), &rows))
+	require.Len(t, rows, 1)
+	assert.Equal(t, "https://test.com:443,*", rows[0]["url"])
+	assert.Equal(t, preferencesFile, rows[0]["path"])
+}
+
+func TestRunQueryCSV(t *testing.T) {
+	preferencesFile := writePreferences(t)
+
+	var stdout, stderr bytes.Buffer
+	code := runQuery([]string{"--format=csv", "--where", "path=" + preferencesFile, "chrome_preferences"}, &stdout, &stderr)
+	require.Equal(t, 0, code, stderr.String())
+
+	records, err := csv.NewReader(&stdout).ReadAll()
+	require.NoError(t, err)
+	require.Len(t, records, 10)
+	assert.Equal(t, "category", records[0][0])
+}
+
+func TestRunQueryErrors(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	assert.Equal(t, 2, runQuery([]string{"not_a_table"}, &stdout, &stderr))
+	assert.Equal(t, 2, runQuery([]string{"chrome_preferences", "--where", "nocolumn=1"}, &stdout, &stderr))
+	assert.Equal(t, 2, runQuery([]string{"chrome_preferences", "--where", "path"}, &stdout, &stderr))
+	assert.Equal(t, 2, runQuery([]string{"chrome_preferences", "--format", "xml", "--where", "path=/nonexistent"}, &stdout, &stderr))
+	assert.Equal(t, 2, runQuery(nil, &stdout, &stderr))
+}
+
+func TestRunQueryFormatCheckedFirst(t *testing.T) {
+	// The table isn't run for an unknown format
+	invocations := registry.GetStats("chrome_preferences").Invocations
+	var stdout, stderr bytes.Buffer
+	assert.Equal(t, 2, runQuery([]string{"chrome_preferences", "--format", "xml"}, &stdout, &stderr))
+	assert.Contains(t, stderr.String(), `Unknown format "xml"`)
+	assert.Equal(t, invocations, registry.GetStats("chrome_preferences").Invocations)
+	assert.Empty(t, stdout.String())
+}
+
+func TestRunQueryTimeout(t *testing.T) {
+	// The table returns its rows once past the deadline, if it has one
+	registry.Register(registry.Table{
+		Name:    "query_test_slow",
+		Columns: []table.ColumnDefinition{table.TextColumn("name")},
+		Generate: func(ctx context.Context, _ table.QueryContext) ([]map[string]string, error) {
+			if _, ok := ctx.Deadline(); ok {
+				<-ctx.Done()
+				utils.RecordError("query_test_slow", "alice", "/home/alice", ctx.Err())
+			}
+			return []map[string]string{{"name": "first"}}, nil
+		},
+	})
+	defer registry.SetDefaultTimeout(registry.DefaultTimeout)
+
+	var stdout, stderr bytes.Buffer
+	assert.Equal(t, 1, runQuery([]string{"query_test_slow", "--format", "csv", "--table-timeout", "10ms"}, &stdout, &stderr))
+	assert.Equal(t, "name\nfirst\n", stdout.String())
+	assert.Contains(t, stderr.String(), "/home/alice: context deadline exceeded (timeout)")
+	assert.Contains(t, stderr.String(), "partial")
+
+	// No deadline by default
+	stdout.Reset()
+	stderr.Reset()
+	assert.Equal(t, 0, runQuery([]string{"query_test_slow", "--format", "csv"}, &stdout, &stderr))
+	assert.Equal(t, "name\nfirst\n", stdout.String())
+	assert.Empty(t, stderr.String())
+}
@@ -0,0 +1,14 @@
+// Package all registers every table of this repository with the registry.
+// Import it for its side effects.
+package all
+
+import (
+	_ "github.com/nachorpaez/osquery-extensions/tables/chrome_extensions"
+	_ "github.com/nachorpaez/osquery-extensions/tables/chrome_extensions_dns"
+	_ "github.com/nachorpaez/osquery-extensions/tables/chrome_preferences"
+	_ "github.com/nachorpaez/osquery-extensions/tables/chrome_profiles"
+	_ "github.com/nachorpaez/osquery-extensions/tables/extension_errors"
+	_ "github.com/nachorpaez/osquery-extensions/tables/extension_info"
+	_ "github.com/nachorpaez/osquery-extensions/tables/vscode_extensions"
+	_ "github.com/nachorpaez/osquery-extensions/tables/vscode_settings"
+)
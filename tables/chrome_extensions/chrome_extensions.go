@@ -0,0 +1,162 @@
+package chrome_extensions
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/nachorpaez/osquery-extensions/pkg/registry"
+	"github.com/nachorpaez/osquery-extensions/pkg/utils"
+	"github.com/osquery/osquery-go/plugin/table"
+	"github.com/pkg/errors"
+)
+
+func init() {
+	registry.Register(registry.Table{
+		Name:      "chrome_extensions",
+		Columns:   ChromeExtensionsColumns(),
+		Generate:  ChromeExtensionsGenerate,
+		Platforms: []string{"darwin", "linux", "windows"},
+	})
+}
+
+func ChromeExtensionsColumns() []table.ColumnDefinition {
+	return []table.ColumnDefinition{
+		table.TextColumn("browser_type"),
+		table.TextColumn("install_type"),
+		table.TextColumn("profile"),
+		table.TextColumn("profile_name"),
+		table.TextColumn("user"),
+		table.BigIntColumn("uid"),
+		table.TextColumn("extension_id"),
+		table.TextColumn("name"),
+		table.TextColumn("version"),
+		table.IntegerColumn("manifest_version"),
+		table.TextColumn("location"),
+		table.BigIntColumn("install_time"),
+		table.IntegerColumn("from_webstore"),
+		table.TextColumn("update_url"),
+		table.TextColumn("state"),
+		table.TextColumn("disable_reasons"),
+		table.TextColumn("permissions"),
+		table.TextColumn("host_permissions"),
+		table.TextColumn("path"),
+	}
+}
+
+// splitPermissions returns the API permissions and the host permissions of
+// a manifest. Manifest V2 extensions declare hosts in the permissions list.
+func splitPermissions(manifest *utils.ChromeExtensionManifest) ([]string, []string) {
+	var permissions []string
+	hostPermissions := append([]string{}, manifest.HostPermissions...)
+
+	for _, p := range manifest.Permissions {
+		permission, ok := p.(string)
+		if !ok {
+			continue
+		}
+		if permission == "<all_urls>" || strings.Contains(permission, "://") {
+			hostPermissions = append(hostPermissions, permission)
+			continue
+		}
+		permissions = append(permissions, permission)
+	}
+	return permissions, hostPermissions
+}
+
+// parseDisableReasons handles both the legacy bitmask and the list format
+// used by newer Chrome versions.
+func parseDisableReasons(raw json.RawMessage) string {
+	if len(raw) == 0 {
+		return ""
+	}
+	var bitmask int
+	if err := json.Unmarshal(raw, &bitmask); err == nil {
+		if bitmask == 0 {
+			return ""
+		}
+		return strconv.Itoa(bitmask)
+	}
+	var reasons []int
+	if err := json.Unmarshal(raw, &reasons); err == nil {
+		values := make([]string, 0, len(reasons))
+		for _, r := range reasons {
+			values = append(values, strconv.Itoa(r))
+		}
+		return strings.Join(values, ",")
+	}
+	return ""
+}
+
+func extensionState(settings *utils.ChromeExtensionSettings, disableReasons string) string {
+	if settings.State != nil {
+		if *settings.State == 1 {
+			return "enabled"
+		}
+		return "disabled"
+	}
+	if disableReasons != "" {
+		return "disabled"
+	}
+	return "enabled"
+}
+
+func parseExtensions(ctx context.Context, chromeProfile utils.ChromeProfilePath) ([]map[string]string, error) {
+	var results []map[string]string
+
+	extensions, err := utils.GetChromeExtensions(ctx, chromeProfile.Value)
+	if err != nil {
+		return nil, errors.Wrap(err, "reading extensions")
+	}
+
+	for id, extension := range extensions {
+		setting, manifest := extension.Settings, extension.Manifest
+
+		permissions, hostPermissions := splitPermissions(manifest)
+		disableReasons := parseDisableReasons(setting.DisableReasons)
+
+		results = append(results, map[string]string{
+			"browser_type":     utils.GetChromeBrowserName(chromeProfile.Type),
+			"install_type":     string(chromeProfile.InstallType),
+			"profile":          filepath.Base(chromeProfile.Value),
+			"profile_name":     chromeProfile.Name,
+			"user":             chromeProfile.UserName,
+			"uid":              chromeProfile.UID,
+			"extension_id":     id,
+			"name":             extension.Name,
+			"version":          manifest.Version,
+			"manifest_version": strconv.Itoa(manifest.ManifestVersion),
+			"location":         utils.GetChromeExtensionLocation(setting.Location),
+			"install_time":     strconv.FormatInt(utils.ChromeTimeToUnix(setting.InstallTime), 10),
+			"from_webstore":    strconv.Itoa(utils.Btoi(setting.FromWebstore)),
+			"update_url":       manifest.UpdateURL,
+			"state":            extensionState(setting, disableReasons),
+			"disable_reasons":  disableReasons,
+			"permissions":      strings.Join(permissions, ","),
+			"host_permissions": strings.Join(hostPermissions, ","),
+			"path":             extension.Path,
+		})
+	}
+
+	return results, nil
+}
+
+// NewChromeExtensionsGenerate returns a generate function discovering and
+// reading the profiles on fsys instead of the host filesystem.
+func NewChromeExtensionsGenerate(fsys utils.FileSystem) table.GenerateFunc {
+	return utils.GenerateWithFileSystem(fsys, ChromeExtensionsGenerate)
+}
+
+// Per docs generator function has to return an array of map of strings
+func ChromeExtensionsGenerate(ctx context.Context, queryContext table.QueryContext) ([]map[string]string, error) {
+	profileList, err := utils.GetChromeProfilePathList(ctx, utils.ChromeProfileOptsFromQueryContext(queryContext)...)
+	if err != nil {
+		log.Printf("Error retrieving Chrome profiles list: %s", err)
+		return nil, errors.Wrap(err, "retrieving Chrome profile list")
+	}
+
+	return utils.ScanChromeProfiles(ctx, "chrome_extensions", profileList, parseExtensions), nil
+}
@@ -0,0 +1,188 @@
+package chrome_extensions
+
+import (
+	"bytes"
+	"context"
+	_ "embed"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"testing/fstest"
+
+	"github.com/nachorpaez/osquery-extensions/pkg/utils"
+	"github.com/osquery/osquery-go/plugin/table"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+//go:embed test_Preferences
+var testPreferences []byte
+
+//go:embed test_SecurePreferences
+var testSecurePreferences []byte
+
+//go:embed test_manifest.json
+var testManifest []byte
+
+//go:embed test_messages.json
+var testMessages []byte
+
+const unpackedManifest = `{
+    "manifest_version": 2,
+    "name": "Dev Tools Helper",
+    "permissions": ["cookies", "https://*.github.com/*", {"fileSystem": ["write"]}],
+    "version": "0.1.0"
+}`
+
+func writeFile(t *testing.T, path string, content []byte) {
+	require.NoError(t, os.MkdirAll(filepath.Dir(path), 0700))
+	require.NoError(t, os.WriteFile(path, content, 0600))
+}
+
+func TestParseExtensions(t *testing.T) {
+	// Create a temporary profile and an unpacked extension outside of it
+	tempDir := t.TempDir()
+	profileDir := filepath.Join(tempDir, "Default")
+	unpackedDir := filepath.Join(tempDir, "unpacked")
+	webstoreDir := filepath.Join(profileDir, "Extensions", "aeblfdkhhhdcdjpifhhbdiojplfjncoa", "8.10.36_0")
+
+	writeFile(t, filepath.Join(profileDir, utils.ProfilePreferencesFile), testPreferences)
+	writeFile(t, filepath.Join(profileDir, utils.SecureProfilePreferencesFile),
+		bytes.ReplaceAll(testSecurePreferences, []byte("UNPACKED_PATH"), []byte(unpackedDir)))
+	writeFile(t, filepath.Join(webstoreDir, "manifest.json"), testManifest)
+	writeFile(t, filepath.Join(webstoreDir, "_locales", "en", "messages.json"), testMessages)
+	writeFile(t, filepath.Join(unpackedDir, "manifest.json"), []byte(unpackedManifest))
+
+	chromeProfile := utils.ChromeProfilePath{
+		UserName:    "user1",
+		UID:         "1001",
+		Value:       profileDir,
+		Type:        utils.GoogleChrome,
+		Name:        "Personal",
+		InstallType: utils.NativeInstall,
+	}
+
+	results, err := parseExtensions(context.Background(), chromeProfile)
+	assert.NoError(t, err)
+	assert.Len(t, results, 3)
+
+	expectedRows := []map[string]string{
+		{
+			"browser_type":     "chrome",
+			"install_type":     "native",
+			"profile":          "Default",
+			"profile_name":     "Personal",
+			"user":             "user1",
+			"uid":              "1001",
+			"extension_id":     "aeblfdkhhhdcdjpifhhbdiojplfjncoa",
+			"name":             "1Password – Password Manager",
+			"version":          "8.10.36",
+			"manifest_version": "3",
+			"location":         "internal",
+			"install_time":     "1712775205",
+			"from_webstore":    "1",
+			"update_url":       "https://clients2.google.com/service/update2/crx",
+			"state":            "disabled",
+			"disable_reasons":  "1",
+			"permissions":      "storage,tabs,nativeMessaging",
+			"host_permissions": "<all_urls>",
+			"path":             webstoreDir,
+		},
+		{
+			"browser_type":     "chrome",
+			"install_type":     "native",
+			"profile":          "Default",
+			"profile_name":     "Personal",
+			"user":             "user1",
+			"uid":              "1001",
+			"extension_id":     "dgjhfomjieaadpoljlnidmbgkdffpack",
+			"name":             "Dev Tools Helper",
+			"version":          "0.1.0",
+			"manifest_version": "2",
+			"location":         "unpacked",
+			"install_time":     "1714925212",
+			"from_webstore":    "0",
+			"update_url":       "",
+			"state":            "enabled",
+			"disable_reasons":  "",
+			"permissions":      "cookies",
+			"host_permissions": "https://*.github.com/*",
+			"path":             unpackedDir,
+		},
+		{
+			"browser_type":     "chrome",
+			"install_type":     "native",
+			"profile":          "Default",
+			"profile_name":     "Personal",
+			"user":             "user1",
+			"uid":              "1001",
+			"extension_id":     "mhjfbmdgcfjbbpaeojofohoefgiehjai",
+			"name":             "Chrome PDF Viewer",
+			"version":          "1",
+			"manifest_version": "2",
+			"location":         "component",
+			"install_time":     "0",
+			"from_webstore":    "0",
+			"update_url":       "",
+			"state":            "enabled",
+			"disable_reasons":  "",
+			"permissions":      "contentSettings,metricsPrivate",
+			"host_permissions": "chrome://resources/",
+			"path":             "/opt/google/chrome/resources/pdf",
+		},
+	}
+
+	assert.ElementsMatch(t, expectedRows, results)
+}
+
+func TestChromeExtensionsGenerateOnFileSystem(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("fixture uses the Linux directory layout")
+	}
+
+	fsys := fstest.MapFS{
+		"etc/passwd":                            {Data: []byte("alice:x:1000:1000::/home/alice:/bin/bash\nbob:x:1001:1001::/home/bob:/bin/bash\n")},
+		"home/alice/dev/unpacked/manifest.json": {Data: []byte(unpackedManifest)},
+		"opt/unpacked/manifest.json":            {Data: []byte(unpackedManifest)},
+	}
+	// The same extensions for two users in different browsers, bob's
+	// unpacked extension is outside of the home directory
+	for profileDir, unpackedDir := range map[string]string{
+		"home/alice/.config/google-chrome/Default":               "/home/alice/dev/unpacked",
+		"home/bob/.config/BraveSoftware/Brave-Browser/Profile 2": "/opt/unpacked",
+	} {
+		webstoreDir := profileDir + "/Extensions/aeblfdkhhhdcdjpifhhbdiojplfjncoa/8.10.36_0"
+		securePreferences := bytes.ReplaceAll(testSecurePreferences, []byte("UNPACKED_PATH"), []byte(unpackedDir))
+		fsys[profileDir+"/"+utils.ProfilePreferencesFile] = &fstest.MapFile{Data: testPreferences}
+		fsys[profileDir+"/"+utils.SecureProfilePreferencesFile] = &fstest.MapFile{Data: securePreferences}
+		fsys[webstoreDir+"/manifest.json"] = &fstest.MapFile{Data: testManifest}
+		fsys[webstoreDir+"/_locales/en/messages.json"] = &fstest.MapFile{Data: testMessages}
+	}
+
+	before := len(utils.RecentErrors())
+	results, err := NewChromeExtensionsGenerate(utils.NewFileSystem(fsys))(context.Background(), table.QueryContext{})
+	require.NoError(t, err)
+
+	counts := map[string]int{}
+	unpackedNames := map[string]string{}
+	for _, row := range results {
+		counts[row["user"]+"/"+row["uid"]+"/"+row["browser_type"]+"/"+row["profile"]]++
+		if row["extension_id"] == "aeblfdkhhhdcdjpifhhbdiojplfjncoa" {
+			assert.Equal(t, "1Password – Password Manager", row["name"])
+		}
+		if row["location"] == "unpacked" {
+			unpackedNames[row["user"]] = row["name"]
+		}
+	}
+	// Extensions referenced by absolute path are read outside of the home
+	// directory, the missing component extension isn't an error
+	assert.Equal(t, map[string]string{"alice": "Dev Tools Helper", "bob": "Dev Tools Helper"}, unpackedNames)
+	for _, e := range utils.RecentErrors()[before:] {
+		assert.NotEqual(t, "chrome_extensions", e.Table, e.Message)
+	}
+	assert.Equal(t, map[string]int{
+		"alice/1000/chrome/Default": 3,
+		"bob/1001/brave/Profile 2":  3,
+	}, counts)
+}
@@ -3,14 +3,18 @@ package chrome_extensions_dns
 import (
 	"context"
 	"encoding/base64"
+	"encoding/binary"
 	"encoding/json"
 	"fmt"
 	"log"
+	"maps"
 	"net/url"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 
+	"github.com/nachorpaez/osquery-extensions/pkg/registry"
 	"github.com/nachorpaez/osquery-extensions/pkg/utils"
 	"github.com/osquery/osquery-go/plugin/table"
 	"github.com/pkg/errors"
@@ -20,49 +24,279 @@ import (
 type NetworkState struct {
 	Net struct {
 		HTTPServerProperties struct {
-			Servers                   []Server                   `json:"servers"`
-			BrokenAlternativeServices []BrokenAlternativeService `json:"broken_alternative_services"`
+			Servers                           []Server                   `json:"servers"`
+			BrokenAlternativeServices         []BrokenAlternativeService `json:"broken_alternative_services"`
+			RecentlyBrokenAlternativeServices []BrokenAlternativeService `json:"recently_broken_alternative_services"`
 		} `json:"http_server_properties"`
 	} `json:"net"`
 }
 
 type Server struct {
-	Server        string        `json:"server"`
-	Anonymization []interface{} `json:"anonymization"`
+	Server             string               `json:"server"`
+	Anonymization      []interface{}        `json:"anonymization"`
+	SupportsSpdy       bool                 `json:"supports_spdy"`
+	AlternativeService []AlternativeService `json:"alternative_service"`
+	NetworkStats       struct {
+		SRTT int64 `json:"srtt"`
+	} `json:"network_stats"`
+}
+
+type AlternativeService struct {
+	Host        string `json:"host"`
+	Port        int    `json:"port"`
+	ProtocolStr string `json:"protocol_str"`
+	Expiration  string `json:"expiration"`
 }
 
 type BrokenAlternativeService struct {
 	Host          string        `json:"host"`
+	Port          int           `json:"port"`
+	ProtocolStr   string        `json:"protocol_str"`
 	Anonymization []interface{} `json:"anonymization"`
+	BrokenCount   int           `json:"broken_count"`
 	BrokenUntil   string        `json:"broken_until"`
 }
 
+func init() {
+	registry.Register(registry.Table{
+		Name:      "chrome_extensions_dns",
+		Columns:   ChromeExtensionsDNSColumns(),
+		Generate:  ChromeExtensionsDNSGenerate,
+		Platforms: []string{"darwin", "linux", "windows"},
+	})
+}
+
 // ChromeExtensionDNSColumns defines the columns for the osquery table.
 func ChromeExtensionsDNSColumns() []table.ColumnDefinition {
 	return []table.ColumnDefinition{
 		table.TextColumn("browser_type"),
+		table.TextColumn("install_type"),
 		table.TextColumn("profile"),
+		table.TextColumn("profile_name"),
 		table.TextColumn("extension_id"),
+		table.TextColumn("extension_name"),
+		table.TextColumn("extension_version"),
+		table.TextColumn("extension_location"),
+		table.IntegerColumn("orphaned"),
+		table.TextColumn("partition_site"),
+		table.TextColumn("frame_site"),
+		table.IntegerColumn("is_cross_site"),
+		table.TextColumn("nonce"),
 		table.TextColumn("domain"),
 		table.TextColumn("type"),
+		table.IntegerColumn("port"),
+		table.TextColumn("scheme"),
+		table.TextColumn("protocol"),
+		table.TextColumn("alternative_host"),
+		table.IntegerColumn("alternative_port"),
+		table.IntegerColumn("supports_spdy"),
+		table.BigIntColumn("expiration"),
+		table.BigIntColumn("srtt"),
+		table.IntegerColumn("broken_count"),
+		table.BigIntColumn("broken_until"),
+		table.TextColumn("path"),
 		table.TextColumn("user"),
+		table.BigIntColumn("uid"),
 	}
 }
 
-// decodeAnonymization decodes a base64 string containing a Chrome extension ID.
-// It returns the extension ID if found; otherwise, an empty string.
-func decodeAnonymization(anonymizationValue interface{}) string {
-	if str, ok := anonymizationValue.(string); ok {
-		decoded, err := base64.StdEncoding.DecodeString(str)
-		if err != nil {
-			return ""
-		}
-		decodedStr := string(decoded)
-		if strings.Contains(decodedStr, "chrome-extension://") {
-			parts := strings.Split(decodedStr, "chrome-extension://")
-			return parts[len(parts)-1]
+// AnonymizationKey is the decoded NetworkAnonymizationKey used by Chrome to
+// partition its network state.
+type AnonymizationKey struct {
+	TopFrameSite string
+	FrameSite    string
+	IsCrossSite  bool
+	Nonce        string
+}
+
+// pickleReader reads values from a serialized base::Pickle. All values are
+// little endian and aligned to 4 bytes.
+type pickleReader struct {
+	payload []byte
+	offset  int
+}
+
+func newPickleReader(data []byte) (*pickleReader, error) {
+	if len(data) < 4 {
+		return nil, errors.New("pickle header too short")
+	}
+	size := int(binary.LittleEndian.Uint32(data))
+	if size > len(data)-4 {
+		return nil, errors.New("pickle payload size exceeds data")
+	}
+	return &pickleReader{payload: data[4 : 4+size]}, nil
+}
+
+func (p *pickleReader) remaining() int {
+	return len(p.payload) - p.offset
+}
+
+func (p *pickleReader) read(size int) ([]byte, error) {
+	aligned := (size + 3) &^ 3
+	if size < 0 || aligned > p.remaining() {
+		return nil, errors.New("pickle read out of bounds")
+	}
+	value := p.payload[p.offset : p.offset+size]
+	p.offset += aligned
+	return value, nil
+}
+
+func (p *pickleReader) readInt32() (int32, error) {
+	value, err := p.read(4)
+	if err != nil {
+		return 0, err
+	}
+	return int32(binary.LittleEndian.Uint32(value)), nil
+}
+
+func (p *pickleReader) readUint64() (uint64, error) {
+	value, err := p.read(8)
+	if err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint64(value), nil
+}
+
+func (p *pickleReader) readString() (string, error) {
+	length, err := p.readInt32()
+	if err != nil {
+		return "", err
+	}
+	value, err := p.read(int(length))
+	if err != nil {
+		return "", err
+	}
+	return string(value), nil
+}
+
+// decodeSerializedSite decodes a SchemefulSite serialized with its nonce: a
+// base64 encoded pickle holding the site, optionally followed by a has-nonce
+// flag and the two halves of the nonce token.
+func decodeSerializedSite(value string) (string, string, error) {
+	decoded, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		return "", "", errors.Wrap(err, "decoding base64")
+	}
+	reader, err := newPickleReader(decoded)
+	if err != nil {
+		return "", "", err
+	}
+	site, err := reader.readString()
+	if err != nil {
+		return "", "", errors.Wrap(err, "reading site")
+	}
+	if reader.remaining() < 4 {
+		return site, "", nil
+	}
+	hasNonce, err := reader.readInt32()
+	if err != nil || hasNonce == 0 {
+		return site, "", nil
+	}
+	high, err := reader.readUint64()
+	if err != nil {
+		return "", "", errors.Wrap(err, "reading nonce")
+	}
+	low, err := reader.readUint64()
+	if err != nil {
+		return "", "", errors.Wrap(err, "reading nonce")
+	}
+	return site, fmt.Sprintf("%016X%016X", high, low), nil
+}
+
+// decodeSite returns the site and nonce of a serialized site. Older Chrome
+// versions stored the site as a plain string.
+func decodeSite(value string) (string, string) {
+	if strings.Contains(value, "://") {
+		return value, ""
+	}
+	site, nonce, err := decodeSerializedSite(value)
+	if err != nil {
+		return "", ""
+	}
+	return site, nonce
+}
+
+// decodeAnonymization decodes the "anonymization" list of a server entry.
+// The first element is the serialized top-frame site. The second one is the
+// is-cross-site flag or, in older versions, the serialized frame site.
+// It returns false for empty (transient) keys.
+func decodeAnonymization(anonymization []interface{}) (AnonymizationKey, bool) {
+	var key AnonymizationKey
+	if len(anonymization) == 0 {
+		return key, false
+	}
+	topFrame, ok := anonymization[0].(string)
+	if !ok {
+		return key, false
+	}
+	key.TopFrameSite, key.Nonce = decodeSite(topFrame)
+	if key.TopFrameSite == "" {
+		return key, false
+	}
+
+	if len(anonymization) > 1 {
+		switch second := anonymization[1].(type) {
+		case bool:
+			key.IsCrossSite = second
+		case string:
+			key.FrameSite, _ = decodeSite(second)
+			key.IsCrossSite = key.FrameSite != "" && key.FrameSite != key.TopFrameSite
 		}
 	}
+	return key, true
+}
+
+// extensionIDFromSite returns the extension ID of a chrome-extension:// site
+func extensionIDFromSite(site string) string {
+	if id, found := strings.CutPrefix(site, "chrome-extension://"); found {
+		return strings.TrimSuffix(id, "/")
+	}
+	return ""
+}
+
+// extensionInfo holds the details of an extension returned in each row
+type extensionInfo struct {
+	Name     string
+	Version  string
+	Location string
+}
+
+// resolveExtension looks up an extension ID in the profile. It returns
+// orphaned as "1" when the extension is no longer registered, in which case
+// the details come from any files left behind in the Extensions directory.
+func resolveExtension(ctx context.Context, profilePath, extID string, installed map[string]utils.ChromeExtension) (extensionInfo, string) {
+	if extID == "" {
+		return extensionInfo{}, ""
+	}
+	if extension, ok := installed[extID]; ok {
+		return extensionInfo{
+			Name:     extension.Name,
+			Version:  extension.Manifest.Version,
+			Location: utils.GetChromeExtensionLocation(extension.Settings.Location),
+		}, "0"
+	}
+
+	orphaned := "1"
+	if installed == nil {
+		orphaned = ""
+	}
+	if leftover, ok := utils.GetLeftoverChromeExtension(ctx, profilePath, extID); ok {
+		return extensionInfo{Name: leftover.Name, Version: leftover.Manifest.Version}, orphaned
+	}
+	return extensionInfo{}, orphaned
+}
+
+// serverPort returns the port of a server URL, defaulting on its scheme
+func serverPort(serverURL *url.URL) string {
+	if port := serverURL.Port(); port != "" {
+		return port
+	}
+	switch serverURL.Scheme {
+	case "https", "wss":
+		return "443"
+	case "http", "ws":
+		return "80"
+	}
 	return ""
 }
 
@@ -70,83 +304,195 @@ func decodeAnonymization(anonymizationValue interface{}) string {
 // information about active and broken connections. It returns a slice of maps
 // containing fields such as browser_type, profile, extension_id, domain, and more.
 func analyzeNetworkState(ctx context.Context, profileInfo utils.ChromeProfilePath) ([]map[string]string, error) {
-	var results []map[string]string
-	profileName := filepath.Base(profileInfo.Value)
+	fsys := utils.FileSystemFromContext(ctx)
 	stateFile := filepath.Join(profileInfo.Value, "Network Persistent State")
 
 	// Try alternate path if the first one doesn't exist
-	if _, err := os.Stat(stateFile); os.IsNotExist(err) {
+	if _, err := fsys.Stat(stateFile); os.IsNotExist(err) {
 		stateFile = filepath.Join(profileInfo.Value, "Network", "Network Persistent State")
-		if _, err := os.Stat(stateFile); os.IsNotExist(err) {
-			return nil, fmt.Errorf("network state file not found")
+		if _, err := fsys.Stat(stateFile); os.IsNotExist(err) {
+			// A profile that never made a request has no data, it isn't
+			// an error
+			return nil, nil
 		}
 	}
 
-	data, err := os.ReadFile(stateFile)
+	return analyzeNetworkStateFile(ctx, profileInfo, stateFile)
+}
+
+// analyzeNetworkStateFile parses a Network Persistent State file belonging to
+// the given profile.
+func analyzeNetworkStateFile(ctx context.Context, profileInfo utils.ChromeProfilePath, stateFile string) ([]map[string]string, error) {
+	var results []map[string]string
+	profileName := filepath.Base(profileInfo.Value)
+
+	netState, err := utils.ReadParsed(ctx, "chrome_network_state", stateFile, func(data []byte) (NetworkState, error) {
+		var netState NetworkState
+		err := json.Unmarshal(data, &netState)
+		return netState, err
+	})
+	if utils.IsParseError(err) {
+		log.Printf("Error parsing JSON: %s", err)
+		return nil, errors.Wrap(err, "parsing JSON")
+	}
 	if err != nil {
 		log.Printf("Error reading state file: %s", err)
 		return nil, errors.Wrap(err, "reading state file")
 	}
 
-	var netState NetworkState
-	if err := json.Unmarshal(data, &netState); err != nil {
-		log.Printf("Error parsing JSON: %s", err)
-		return nil, errors.Wrap(err, "parsing JSON")
+	// Installed extensions are used to resolve extension IDs. If the
+	// preferences can't be read we can't tell whether an ID is orphaned.
+	installed, err := utils.GetChromeExtensions(ctx, profileInfo.Value)
+	if err != nil {
+		log.Printf("Error reading extensions of %s: %s", profileInfo.Value, err)
+	}
+
+	// An extension has many rows, each ID is resolved once
+	type resolvedExtension struct {
+		info     extensionInfo
+		orphaned string
+	}
+	resolved := map[string]resolvedExtension{}
+
+	newRow := func(key AnonymizationKey, domain, connectionType string) map[string]string {
+		extID := extensionIDFromSite(key.TopFrameSite)
+		r, ok := resolved[extID]
+		if !ok {
+			r.info, r.orphaned = resolveExtension(ctx, profileInfo.Value, extID, installed)
+			resolved[extID] = r
+		}
+		extension, orphaned := r.info, r.orphaned
+		return map[string]string{
+			"browser_type":       utils.GetChromeBrowserName(profileInfo.Type),
+			"install_type":       string(profileInfo.InstallType),
+			"profile":            profileName,
+			"profile_name":       profileInfo.Name,
+			"extension_id":       extID,
+			"extension_name":     extension.Name,
+			"extension_version":  extension.Version,
+			"extension_location": extension.Location,
+			"orphaned":           orphaned,
+			"partition_site":     key.TopFrameSite,
+			"frame_site":         key.FrameSite,
+			"is_cross_site":      strconv.Itoa(utils.Btoi(key.IsCrossSite)),
+			"nonce":              key.Nonce,
+			"domain":             domain,
+			"type":               connectionType,
+			"port":               "",
+			"scheme":             "",
+			"protocol":           "",
+			"alternative_host":   "",
+			"alternative_port":   "",
+			"supports_spdy":      "",
+			"expiration":         "",
+			"srtt":               "",
+			"broken_count":       "",
+			"broken_until":       "",
+			"path":               stateFile,
+			"user":               profileInfo.UserName,
+			"uid":                profileInfo.UID,
+		}
 	}
 
-	// Process active connections.
+	// Process active connections. Each advertised alternative service
+	// (e.g. QUIC) gets its own row.
 	for _, server := range netState.Net.HTTPServerProperties.Servers {
-		if len(server.Anonymization) > 0 {
-			if extID := decodeAnonymization(server.Anonymization[0]); extID != "" {
-				url, err := url.Parse(server.Server)
-				if err != nil {
-					log.Printf("Error parsing URL: %s", err)
-					continue
-				}
-				results = append(results, map[string]string{
-					"browser_type": utils.GetChromeBrowserName(profileInfo.Type),
-					"profile":      profileName,
-					"extension_id": extID,
-					"domain":       url.Hostname(),
-					"type":         "Active",
-					"user":         profileInfo.UserName,
-				})
+		key, ok := decodeAnonymization(server.Anonymization)
+		if !ok {
+			continue
+		}
+		url, err := url.Parse(server.Server)
+		if err != nil {
+			log.Printf("Error parsing URL: %s", err)
+			continue
+		}
+
+		row := newRow(key, url.Hostname(), "Active")
+		row["port"] = serverPort(url)
+		row["scheme"] = url.Scheme
+		row["protocol"] = url.Scheme
+		row["supports_spdy"] = strconv.Itoa(utils.Btoi(server.SupportsSpdy))
+		if server.NetworkStats.SRTT > 0 {
+			row["srtt"] = strconv.FormatInt(server.NetworkStats.SRTT, 10)
+		}
+
+		if len(server.AlternativeService) == 0 {
+			results = append(results, row)
+			continue
+		}
+		// The port and scheme stay the origin's, the alternative service
+		// can be on another host and port
+		for _, alternative := range server.AlternativeService {
+			altRow := maps.Clone(row)
+			altRow["protocol"] = alternative.ProtocolStr
+			// An empty host is the host of the origin
+			altRow["alternative_host"] = alternative.Host
+			if alternative.Host == "" {
+				altRow["alternative_host"] = url.Hostname()
 			}
+			altRow["alternative_port"] = strconv.Itoa(alternative.Port)
+			altRow["expiration"] = strconv.FormatInt(utils.ChromeTimeToUnix(alternative.Expiration), 10)
+			results = append(results, altRow)
 		}
 	}
 
-	// Process broken connections.
-	for _, broken := range netState.Net.HTTPServerProperties.BrokenAlternativeServices {
-		if len(broken.Anonymization) > 0 {
-			if extID := decodeAnonymization(broken.Anonymization[0]); extID != "" {
-				results = append(results, map[string]string{
-					"browser_type": utils.GetChromeBrowserName(profileInfo.Type),
-					"profile":      profileName,
-					"extension_id": extID,
-					"domain":       broken.Host,
-					"type":         "Broken",
-					"user":         profileInfo.UserName,
-				})
+	// Process broken and recently broken alternative services.
+	brokenServices := map[string][]BrokenAlternativeService{
+		"Broken":         netState.Net.HTTPServerProperties.BrokenAlternativeServices,
+		"RecentlyBroken": netState.Net.HTTPServerProperties.RecentlyBrokenAlternativeServices,
+	}
+	for connectionType, services := range brokenServices {
+		for _, broken := range services {
+			key, ok := decodeAnonymization(broken.Anonymization)
+			if !ok {
+				continue
 			}
+			// Broken entries only know the alternative service
+			row := newRow(key, broken.Host, connectionType)
+			row["port"] = strconv.Itoa(broken.Port)
+			row["protocol"] = broken.ProtocolStr
+			row["alternative_host"] = broken.Host
+			row["alternative_port"] = strconv.Itoa(broken.Port)
+			row["broken_count"] = strconv.Itoa(broken.BrokenCount)
+			// broken_until is already stored as unix time
+			if brokenUntil, err := strconv.ParseInt(broken.BrokenUntil, 10, 64); err == nil {
+				row["broken_until"] = strconv.FormatInt(brokenUntil, 10)
+			}
+			results = append(results, row)
 		}
 	}
 
 	return results, nil
 }
 
+// NewChromeExtensionsDNSGenerate returns a generate function discovering and
+// reading the profiles on fsys instead of the host filesystem.
+func NewChromeExtensionsDNSGenerate(fsys utils.FileSystem) table.GenerateFunc {
+	return utils.GenerateWithFileSystem(fsys, ChromeExtensionsDNSGenerate)
+}
+
 // Per docs generator function has to return an array of map of strings
 func ChromeExtensionsDNSGenerate(ctx context.Context, queryContext table.QueryContext) ([]map[string]string, error) {
 	var results []map[string]string
 
-	profileList, err := utils.GetChromeProfilePathList()
+	// Parse the files given explicitly instead of discovering profiles
+	if paths := utils.GetConstraintValues(queryContext, "path"); len(paths) > 0 {
+		for _, path := range paths {
+			profile := utils.ChromeProfileFromPath(ctx, path)
+			res, err := analyzeNetworkStateFile(utils.WithOwner(ctx, profile.Owner()), profile, path)
+			if err != nil {
+				utils.RecordError("chrome_extensions_dns", profile.UserName, path, err)
+			}
+			results = append(results, res...)
+		}
+		return results, nil
+	}
+
+	profileList, err := utils.GetChromeProfilePathList(ctx, utils.ChromeProfileOptsFromQueryContext(queryContext)...)
 	if err != nil {
 		log.Printf("Error retrieving Chrome profiles list: %s", err)
 		return nil, errors.Wrap(err, "retrieving Chrome profile list")
 	}
 
-	for _, profile := range profileList {
-		res, _ := analyzeNetworkState(ctx, profile)
-		results = append(results, res...)
-	}
-	return results, nil
+	return utils.ScanChromeProfiles(ctx, "chrome_extensions_dns", profileList, analyzeNetworkState), nil
 }
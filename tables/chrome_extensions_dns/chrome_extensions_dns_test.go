@@ -5,15 +5,36 @@ import (
 	_ "embed"
 	"os"
 	"path/filepath"
+	"runtime"
+	"strings"
 	"testing"
+	"testing/fstest"
 
 	"github.com/nachorpaez/osquery-extensions/pkg/utils"
+	"github.com/osquery/osquery-go/plugin/table"
 	"github.com/stretchr/testify/assert"
 )
 
 //go:embed test_NetworkPersistentState
 var testNetworkPersistentState []byte
 
+const testPreferences = `{
+    "extensions": {
+        "settings": {
+            "aeblfdkhhhdcdjpifhhbdiojplfjncoa": {
+                "location": 1,
+                "path": "aeblfdkhhhdcdjpifhhbdiojplfjncoa/8.10.36_0"
+            }
+        }
+    }
+}`
+
+const testManifest = `{
+    "manifest_version": 3,
+    "name": "1Password – Password Manager",
+    "version": "8.10.36"
+}`
+
 func TestAnalyzeNetworkState(t *testing.T) {
 	// Create a temporary directory for testing
 	tempDir := t.TempDir()
@@ -23,48 +44,355 @@ func TestAnalyzeNetworkState(t *testing.T) {
 	err := os.WriteFile(stateFilePath, testNetworkPersistentState, 0600)
 	assert.NoError(t, err)
 
+	// Register one of the extensions in the profile. The other one has been
+	// removed, leaving its files behind, and must be reported as orphaned
+	leftoverDir := filepath.Join(tempDir, "Extensions", "dgjhfomjieaadpoljlnidmbgkdffpack", "1.0.0_0")
+	err = os.MkdirAll(leftoverDir, 0700)
+	assert.NoError(t, err)
+	err = os.WriteFile(filepath.Join(leftoverDir, "manifest.json"), []byte(`{"name": "Removed Helper", "version": "1.0.0"}`), 0600)
+	assert.NoError(t, err)
+	extDir := filepath.Join(tempDir, "Extensions", "aeblfdkhhhdcdjpifhhbdiojplfjncoa", "8.10.36_0")
+	err = os.MkdirAll(extDir, 0700)
+	assert.NoError(t, err)
+	err = os.WriteFile(filepath.Join(extDir, "manifest.json"), []byte(testManifest), 0600)
+	assert.NoError(t, err)
+	err = os.WriteFile(filepath.Join(tempDir, utils.ProfilePreferencesFile), []byte(testPreferences), 0600)
+	assert.NoError(t, err)
+
 	// Build a mock ChromeProfilePath
 	mockProfile := utils.ChromeProfilePath{
-		UserName: "testuser",
-		Type:     utils.GoogleChrome, // see what your code expects
-		Value:    tempDir,            // directory containing the "Network Persistent State" file
+		UserName:    "testuser",
+		UID:         "1001",
+		Type:        utils.GoogleChrome, // see what your code expects
+		Value:       tempDir,            // directory containing the "Network Persistent State" file
+		Name:        "Personal",
+		InstallType: utils.NativeInstall,
 	}
 
 	// Call the function we want to test
 	results, err := analyzeNetworkState(context.Background(), mockProfile)
 	assert.NoError(t, err, "analyzeNetworkState should not return an error")
 
-	// We expect 3 entries from the mock file:
-	//   2 servers with "Active" type
+	// We expect 7 entries from the mock file:
+	//   4 servers with "Active" type, the transient one is skipped, one of
+	//   them with 2 alternative services
 	//   1 broken alt service with "Broken" type
-	assert.Len(t, results, 3)
+	//   1 recently broken alt service with "RecentlyBroken" type
+	assert.Len(t, results, 7)
 
 	expected := []map[string]string{
 		{
-			"browser_type": "chrome",
-			"profile":      filepath.Base(tempDir),
-			"extension_id": "aeblfdkhhhdcdjpifhhbdiojplfjncoa\x00",
-			"domain":       "my.1password.com",
-			"type":         "Active",
-			"user":         "testuser",
+			"browser_type":       "chrome",
+			"install_type":       "native",
+			"profile":            filepath.Base(tempDir),
+			"profile_name":       "Personal",
+			"extension_id":       "aeblfdkhhhdcdjpifhhbdiojplfjncoa",
+			"extension_name":     "1Password – Password Manager",
+			"extension_version":  "8.10.36",
+			"extension_location": "internal",
+			"orphaned":           "0",
+			"partition_site":     "chrome-extension://aeblfdkhhhdcdjpifhhbdiojplfjncoa",
+			"frame_site":         "",
+			"is_cross_site":      "0",
+			"nonce":              "",
+			"domain":             "my.1password.com",
+			"type":               "Active",
+			"port":               "443",
+			"scheme":             "https",
+			"protocol":           "quic",
+			"alternative_host":   "my.1password.com",
+			"alternative_port":   "443",
+			"supports_spdy":      "1",
+			"expiration":         "1714925212",
+			"srtt":               "24560",
+			"broken_count":       "",
+			"broken_until":       "",
+			"path":               stateFilePath,
+			"user":               "testuser",
+			"uid":                "1001",
 		},
 		{
-			"browser_type": "chrome",
-			"profile":      filepath.Base(tempDir),
-			"extension_id": "dgjhfomjieaadpoljlnidmbgkdffpack\x00",
-			"domain":       "github.com",
-			"type":         "Active",
-			"user":         "testuser",
+			"browser_type":       "chrome",
+			"install_type":       "native",
+			"profile":            filepath.Base(tempDir),
+			"profile_name":       "Personal",
+			"extension_id":       "aeblfdkhhhdcdjpifhhbdiojplfjncoa",
+			"extension_name":     "1Password – Password Manager",
+			"extension_version":  "8.10.36",
+			"extension_location": "internal",
+			"orphaned":           "0",
+			"partition_site":     "chrome-extension://aeblfdkhhhdcdjpifhhbdiojplfjncoa",
+			"frame_site":         "",
+			"is_cross_site":      "0",
+			"nonce":              "",
+			"domain":             "my.1password.com",
+			"type":               "Active",
+			"port":               "443",
+			"scheme":             "https",
+			"protocol":           "quic",
+			"alternative_host":   "quic.1password.com",
+			"alternative_port":   "8443",
+			"supports_spdy":      "1",
+			"expiration":         "1714925212",
+			"srtt":               "24560",
+			"broken_count":       "",
+			"broken_until":       "",
+			"path":               stateFilePath,
+			"user":               "testuser",
+			"uid":                "1001",
 		},
 		{
-			"browser_type": "chrome",
-			"profile":      filepath.Base(tempDir),
-			"extension_id": "aeblfdkhhhdcdjpifhhbdiojplfjncoa\x00",
-			"domain":       "b5x-sentry.1passwordservices.com",
-			"type":         "Broken",
-			"user":         "testuser",
+			"browser_type":       "chrome",
+			"install_type":       "native",
+			"profile":            filepath.Base(tempDir),
+			"profile_name":       "Personal",
+			"extension_id":       "dgjhfomjieaadpoljlnidmbgkdffpack",
+			"extension_name":     "Removed Helper",
+			"extension_version":  "1.0.0",
+			"extension_location": "",
+			"orphaned":           "1",
+			"partition_site":     "chrome-extension://dgjhfomjieaadpoljlnidmbgkdffpack",
+			"frame_site":         "",
+			"is_cross_site":      "0",
+			"nonce":              "",
+			"domain":             "github.com",
+			"type":               "Active",
+			"port":               "80",
+			"scheme":             "http",
+			"protocol":           "http",
+			"alternative_host":   "",
+			"alternative_port":   "",
+			"supports_spdy":      "1",
+			"expiration":         "",
+			"srtt":               "",
+			"broken_count":       "",
+			"broken_until":       "",
+			"path":               stateFilePath,
+			"user":               "testuser",
+			"uid":                "1001",
+		},
+		{
+			"browser_type":       "chrome",
+			"install_type":       "native",
+			"profile":            filepath.Base(tempDir),
+			"profile_name":       "Personal",
+			"extension_id":       "",
+			"extension_name":     "",
+			"extension_version":  "",
+			"extension_location": "",
+			"orphaned":           "",
+			"partition_site":     "https://github.com",
+			"frame_site":         "",
+			"is_cross_site":      "0",
+			"nonce":              "",
+			"domain":             "api.github.com",
+			"type":               "Active",
+			"port":               "443",
+			"scheme":             "https",
+			"protocol":           "https",
+			"alternative_host":   "",
+			"alternative_port":   "",
+			"supports_spdy":      "1",
+			"expiration":         "",
+			"srtt":               "",
+			"broken_count":       "",
+			"broken_until":       "",
+			"path":               stateFilePath,
+			"user":               "testuser",
+			"uid":                "1001",
+		},
+		{
+			"browser_type":       "chrome",
+			"install_type":       "native",
+			"profile":            filepath.Base(tempDir),
+			"profile_name":       "Personal",
+			"extension_id":       "",
+			"extension_name":     "",
+			"extension_version":  "",
+			"extension_location": "",
+			"orphaned":           "",
+			"partition_site":     "https://example.org",
+			"frame_site":         "",
+			"is_cross_site":      "1",
+			"nonce":              "0123456789ABCDEFFEDCBA9876543210",
+			"domain":             "cdn.example.net",
+			"type":               "Active",
+			"port":               "443",
+			"scheme":             "https",
+			"protocol":           "https",
+			"alternative_host":   "",
+			"alternative_port":   "",
+			"supports_spdy":      "0",
+			"expiration":         "",
+			"srtt":               "",
+			"broken_count":       "",
+			"broken_until":       "",
+			"path":               stateFilePath,
+			"user":               "testuser",
+			"uid":                "1001",
+		},
+		{
+			"browser_type":       "chrome",
+			"install_type":       "native",
+			"profile":            filepath.Base(tempDir),
+			"profile_name":       "Personal",
+			"extension_id":       "aeblfdkhhhdcdjpifhhbdiojplfjncoa",
+			"extension_name":     "1Password – Password Manager",
+			"extension_version":  "8.10.36",
+			"extension_location": "internal",
+			"orphaned":           "0",
+			"partition_site":     "chrome-extension://aeblfdkhhhdcdjpifhhbdiojplfjncoa",
+			"frame_site":         "",
+			"is_cross_site":      "0",
+			"nonce":              "",
+			"domain":             "b5x-sentry.1passwordservices.com",
+			"type":               "Broken",
+			"port":               "443",
+			"scheme":             "",
+			"protocol":           "quic",
+			"alternative_host":   "b5x-sentry.1passwordservices.com",
+			"alternative_port":   "443",
+			"supports_spdy":      "",
+			"expiration":         "",
+			"srtt":               "",
+			"broken_count":       "5",
+			"broken_until":       "1714925212",
+			"path":               stateFilePath,
+			"user":               "testuser",
+			"uid":                "1001",
+		},
+		{
+			"browser_type":       "chrome",
+			"install_type":       "native",
+			"profile":            filepath.Base(tempDir),
+			"profile_name":       "Personal",
+			"extension_id":       "aeblfdkhhhdcdjpifhhbdiojplfjncoa",
+			"extension_name":     "1Password – Password Manager",
+			"extension_version":  "8.10.36",
+			"extension_location": "internal",
+			"orphaned":           "0",
+			"partition_site":     "chrome-extension://aeblfdkhhhdcdjpifhhbdiojplfjncoa",
+			"frame_site":         "",
+			"is_cross_site":      "0",
+			"nonce":              "",
+			"domain":             "events.1password.com",
+			"type":               "RecentlyBroken",
+			"port":               "443",
+			"scheme":             "",
+			"protocol":           "quic",
+			"alternative_host":   "events.1password.com",
+			"alternative_port":   "443",
+			"supports_spdy":      "",
+			"expiration":         "",
+			"srtt":               "",
+			"broken_count":       "2",
+			"broken_until":       "",
+			"path":               stateFilePath,
+			"user":               "testuser",
+			"uid":                "1001",
 		},
 	}
 
 	assert.ElementsMatch(t, expected, results)
 }
+
+func TestDecodeAnonymization(t *testing.T) {
+	// Older versions stored the top-frame and frame sites as plain strings
+	key, ok := decodeAnonymization([]interface{}{"https://example.com", "https://ads.example.net"})
+	assert.True(t, ok)
+	assert.Equal(t, AnonymizationKey{
+		TopFrameSite: "https://example.com",
+		FrameSite:    "https://ads.example.net",
+		IsCrossSite:  true,
+	}, key)
+
+	_, ok = decodeAnonymization([]interface{}{})
+	assert.False(t, ok)
+
+	// Truncated pickle payload
+	_, ok = decodeAnonymization([]interface{}{"OAAAADMAAABjaHJvbWU=", false})
+	assert.False(t, ok)
+}
+
+func TestChromeExtensionsDNSGenerateWithPath(t *testing.T) {
+	// A file copied off a machine, outside of any home directory
+	stateFilePath := filepath.Join(t.TempDir(), "Network Persistent State")
+	err := os.WriteFile(stateFilePath, testNetworkPersistentState, 0600)
+	assert.NoError(t, err)
+
+	queryContext := table.QueryContext{
+		Constraints: map[string]table.ConstraintList{
+			"path": {Constraints: []table.Constraint{
+				{Operator: table.OperatorEquals, Expression: stateFilePath},
+			}},
+		},
+	}
+
+	results, err := ChromeExtensionsDNSGenerate(context.Background(), queryContext)
+	assert.NoError(t, err)
+	assert.Len(t, results, 7)
+	for _, row := range results {
+		assert.Equal(t, stateFilePath, row["path"])
+		assert.Equal(t, "", row["user"])
+		assert.Equal(t, "", row["browser_type"])
+	}
+}
+
+func TestChromeExtensionsDNSGenerateOnFileSystem(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("fixture uses the Linux directory layout")
+	}
+
+	// Older Chrome versions keep the state file in the profile, newer ones
+	// in its Network subdirectory
+	generate := NewChromeExtensionsDNSGenerate(utils.NewFileSystem(fstest.MapFS{
+		"etc/passwd": {Data: []byte("alice:x:1000:1000::/home/alice:/bin/bash\nbob:x:1001:1001::/home/bob:/bin/bash\n")},
+		"home/alice/.config/google-chrome/Default/Preferences":               {Data: []byte("{}")},
+		"home/alice/.config/google-chrome/Default/Network Persistent State":  {Data: testNetworkPersistentState},
+		"home/bob/.config/chromium/Default/Preferences":                      {Data: []byte("{}")},
+		"home/bob/.config/chromium/Default/Network/Network Persistent State": {Data: testNetworkPersistentState},
+		"home/bob/.config/BraveSoftware/Brave-Browser/Default/Preferences":   {Data: []byte("{}")},
+	}))
+
+	before := len(utils.RecentErrors())
+	results, err := generate(context.Background(), table.QueryContext{})
+	assert.NoError(t, err)
+	// bob's Brave profile has no state file, which isn't an error
+	for _, e := range utils.RecentErrors()[before:] {
+		assert.NotContains(t, e.Path, "Brave-Browser")
+	}
+	counts := map[string]int{}
+	for _, row := range results {
+		counts[row["user"]+"/"+row["uid"]+"/"+row["browser_type"]]++
+	}
+	assert.Equal(t, map[string]int{
+		"alice/1000/chrome": 7,
+		"bob/1001/chromium": 7,
+	}, counts)
+}
+
+func TestAnalyzeNetworkStateResolvesExtensionsOnce(t *testing.T) {
+	// Every row of the orphaned extension needs its leftover manifest
+	server := `{"anonymization": ["OAAAADMAAABjaHJvbWUtZXh0ZW5zaW9uOi8vZGdqaGZvbWppZWFhZHBvbGpsbmlkbWJna2RmZnBhY2sA", false], "server": "https://github.com"}`
+	filesRead := func(servers int) int64 {
+		state := `{"net": {"http_server_properties": {"servers": [` + server + strings.Repeat(","+server, servers-1) + `]}}}`
+		ctx := utils.WithFileSystem(context.Background(), utils.NewFileSystem(fstest.MapFS{
+			"profile/Preferences":              {Data: []byte("{}")},
+			"profile/Network Persistent State": {Data: []byte(state)},
+			"profile/Extensions/dgjhfomjieaadpoljlnidmbgkdffpack/1.0.0_0/manifest.json": {
+				Data: []byte(`{"name": "Removed Helper", "version": "1.0.0"}`),
+			},
+		}))
+		stats := &utils.ReadStats{}
+		profile := utils.ChromeProfilePath{Value: filepath.FromSlash("/profile")}
+		results, err := analyzeNetworkState(utils.WithReadStats(ctx, stats), profile)
+		assert.NoError(t, err)
+		assert.Len(t, results, servers)
+		for _, row := range results {
+			assert.Equal(t, "Removed Helper", row["extension_name"])
+			assert.Equal(t, "1", row["orphaned"])
+		}
+		return stats.Files.Load()
+	}
+	assert.Equal(t, filesRead(1), filesRead(3))
+}
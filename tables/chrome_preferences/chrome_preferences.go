@@ -3,32 +3,32 @@ package chrome_preferences
 import (
 	"context"
 	"encoding/json"
+	"io/fs"
 	"log"
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
 
+	"github.com/nachorpaez/osquery-extensions/pkg/registry"
 	"github.com/nachorpaez/osquery-extensions/pkg/utils"
 	"github.com/osquery/osquery-go/plugin/table"
 	"github.com/pkg/errors"
 )
 
 type Settings struct {
-	Expiration   string `json:"expiration,omitempty"`
-	LastModified string `json:"last_modified,omitempty"`
-	Model        int    `json:"model,omitempty"`
-	Setting      int    `json:"setting"`
+	Expiration   string          `json:"expiration,omitempty"`
+	LastModified string          `json:"last_modified,omitempty"`
+	LastVisit    string          `json:"last_visit,omitempty"`
+	Model        int             `json:"model,omitempty"`
+	Setting      json.RawMessage `json:"setting"`
 }
 
 type Profile struct {
 	ContentSettings struct {
-		Exceptions struct {
-			Notifications     map[string]Settings `json:"notifications"`
-			Geolocation       map[string]Settings `json:"geolocation"`
-			MediaStreamCamera map[string]Settings `json:"media_stream_camera"`
-			MediaStreamMic    map[string]Settings `json:"media_stream_mic"`
-			Popups            map[string]Settings `json:"popups"`
-		} `json:"exceptions"`
+		// Exceptions maps each content setting category (notifications,
+		// geolocation, cookies...) to its per-pattern settings.
+		Exceptions map[string]map[string]json.RawMessage `json:"exceptions"`
 	} `json:"content_settings"`
 }
 
@@ -36,118 +36,233 @@ type Data struct {
 	Profile Profile `json:"profile"`
 }
 
+// contentSettingNames maps the Chromium ContentSetting enum to a readable name
+var contentSettingNames = map[int]string{
+	0: "default",
+	1: "allow",
+	2: "block",
+	3: "ask",
+	4: "session_only",
+	5: "detect_important_content",
+}
+
+func init() {
+	registry.Register(registry.Table{
+		Name:      "chrome_preferences",
+		Columns:   GoogleChromePreferencesColumns(),
+		Generate:  GoogleChromePreferencesGenerate,
+		Platforms: []string{"darwin", "linux", "windows"},
+	})
+}
+
 func GoogleChromePreferencesColumns() []table.ColumnDefinition {
 	return []table.ColumnDefinition{
 		table.TextColumn("category"),
 		table.TextColumn("url"),
+		table.TextColumn("primary_pattern"),
+		table.TextColumn("secondary_pattern"),
+		table.TextColumn("scheme"),
+		table.TextColumn("host"),
+		table.TextColumn("port"),
 		table.TextColumn("expiration"),
 		table.BigIntColumn("last_modified"),
+		table.BigIntColumn("last_visit"),
 		table.IntegerColumn("model"),
 		table.IntegerColumn("setting"),
+		table.TextColumn("setting_name"),
+		table.TextColumn("source_file"),
+		table.TextColumn("path"),
+		table.TextColumn("profile"),
+		table.TextColumn("profile_name"),
 		table.TextColumn("profile_path"),
 		table.TextColumn("user"),
+		table.BigIntColumn("uid"),
 		table.TextColumn("browser_type"),
+		table.TextColumn("install_type"),
 	}
 }
 
-func parsePreferences(ctx context.Context, chromeProfile utils.ChromeProfilePath) ([]map[string]string, error) {
-	var results []map[string]string
-	preferenceFile := filepath.Join(chromeProfile.Value, "Preferences")
-	fileContent, err := os.ReadFile(preferenceFile)
+// splitPatternPair splits an exception key into its primary and secondary
+// content settings patterns, e.g. "https://example.com:443,*".
+func splitPatternPair(key string) (string, string) {
+	primary, secondary, found := strings.Cut(key, ",")
+	if !found {
+		return key, ""
+	}
+	return primary, secondary
+}
 
-	if err != nil {
-		return nil, errors.Wrap(err, "reading preferences file")
+// parsePattern extracts the scheme, host and port of a content settings
+// pattern such as "https://[*.]example.com:443".
+func parsePattern(pattern string) (string, string, string) {
+	var scheme, host, port string
+
+	rest := pattern
+	if before, after, found := strings.Cut(pattern, "://"); found {
+		scheme = before
+		rest = after
 	}
-	var data Data
-	if err := json.Unmarshal(fileContent, &data); err != nil {
-		return nil, errors.Wrap(err, "unmarshalling preferences file")
+	// Drop any path component (e.g. file:///path patterns)
+	if idx := strings.Index(rest, "/"); idx >= 0 {
+		rest = rest[:idx]
 	}
 
-	for url, preference := range data.Profile.ContentSettings.Exceptions.Geolocation {
-		results = append(results, map[string]string{
-			"category":      "geolocation",
-			"url":           url,
-			"expiration":    preference.Expiration,
-			"last_modified": preference.LastModified,
-			"model":         strconv.Itoa(preference.Model),
-			"setting":       strconv.Itoa(preference.Setting),
-			"profile_path":  chromeProfile.Value,
-			"user":          chromeProfile.UserName,
-			"browser_type":  utils.GetChromeBrowserName(chromeProfile.Type),
-		})
+	host = rest
+	if idx := strings.LastIndex(rest, ":"); idx >= 0 && !strings.Contains(rest[idx:], "]") {
+		host = rest[:idx]
+		port = rest[idx+1:]
 	}
+	return scheme, host, port
+}
 
-	for url, preference := range data.Profile.ContentSettings.Exceptions.Notifications {
-		results = append(results, map[string]string{
-			"category":      "notifications",
-			"url":           url,
-			"expiration":    preference.Expiration,
-			"last_modified": preference.LastModified,
-			"model":         strconv.Itoa(preference.Model),
-			"setting":       strconv.Itoa(preference.Setting),
-			"profile_path":  chromeProfile.Value,
-			"user":          chromeProfile.UserName,
-			"browser_type":  utils.GetChromeBrowserName(chromeProfile.Type),
-		})
+// parseSetting returns the numeric setting and its name. Website settings
+// that store an object instead of a number return empty values.
+func parseSetting(raw json.RawMessage) (string, string) {
+	var setting int
+	if err := json.Unmarshal(raw, &setting); err != nil {
+		return "", ""
 	}
+	return strconv.Itoa(setting), contentSettingNames[setting]
+}
 
-	for url, preference := range data.Profile.ContentSettings.Exceptions.MediaStreamCamera {
-		results = append(results, map[string]string{
-			"category":      "media_stream_camera",
-			"url":           url,
-			"expiration":    preference.Expiration,
-			"last_modified": preference.LastModified,
-			"model":         strconv.Itoa(preference.Model),
-			"setting":       strconv.Itoa(preference.Setting),
-			"profile_path":  chromeProfile.Value,
-			"user":          chromeProfile.UserName,
-			"browser_type":  utils.GetChromeBrowserName(chromeProfile.Type),
-		})
+// contentSetting is a single exception along with the file it was read from.
+type contentSetting struct {
+	raw        json.RawMessage
+	sourceFile string
+}
+
+// profilePreferenceFiles returns the preference files of a profile, in the
+// order Chrome merges them.
+func profilePreferenceFiles(profilePath string) []string {
+	return []string{
+		filepath.Join(profilePath, utils.ProfilePreferencesFile),
+		filepath.Join(profilePath, utils.SecureProfilePreferencesFile),
 	}
+}
+
+// readContentSettings merges the content settings exceptions of the given
+// files. As Chrome does when loading a profile, values from later files
+// (Secure Preferences) take precedence.
+func readContentSettings(ctx context.Context, files []string) (map[string]map[string]contentSetting, error) {
+	merged := map[string]map[string]contentSetting{}
+	found := false
 
-	for url, preference := range data.Profile.ContentSettings.Exceptions.MediaStreamMic {
-		results = append(results, map[string]string{
-			"category":      "media_stream_mic",
-			"url":           url,
-			"expiration":    preference.Expiration,
-			"last_modified": preference.LastModified,
-			"model":         strconv.Itoa(preference.Model),
-			"setting":       strconv.Itoa(preference.Setting),
-			"profile_path":  chromeProfile.Value,
-			"user":          chromeProfile.UserName,
-			"browser_type":  utils.GetChromeBrowserName(chromeProfile.Type),
+	for _, file := range files {
+		fileName := filepath.Base(file)
+		data, err := utils.ReadParsed(ctx, "chrome_preferences", file, func(fileContent []byte) (Data, error) {
+			var data Data
+			err := json.Unmarshal(fileContent, &data)
+			return data, err
 		})
+		if os.IsNotExist(err) {
+			continue
+		}
+		if utils.IsParseError(err) {
+			return nil, errors.Wrapf(err, "unmarshalling %s file", fileName)
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "reading %s file", fileName)
+		}
+		found = true
+
+		for category, exceptions := range data.Profile.ContentSettings.Exceptions {
+			if _, ok := merged[category]; !ok {
+				merged[category] = map[string]contentSetting{}
+			}
+			for url, raw := range exceptions {
+				merged[category][url] = contentSetting{raw: raw, sourceFile: file}
+			}
+		}
 	}
-	for url, preference := range data.Profile.ContentSettings.Exceptions.Popups {
-		results = append(results, map[string]string{
-			"category":      "popups",
-			"url":           url,
-			"expiration":    preference.Expiration,
-			"last_modified": preference.LastModified,
-			"model":         strconv.Itoa(preference.Model),
-			"setting":       strconv.Itoa(preference.Setting),
-			"profile_path":  chromeProfile.Value,
-			"user":          chromeProfile.UserName,
-			"browser_type":  utils.GetChromeBrowserName(chromeProfile.Type),
-		})
+
+	if !found {
+		return nil, errors.Wrap(fs.ErrNotExist, "no preferences file found")
+	}
+	return merged, nil
+}
+
+func parsePreferences(ctx context.Context, chromeProfile utils.ChromeProfilePath) ([]map[string]string, error) {
+	return parsePreferenceFiles(ctx, chromeProfile, profilePreferenceFiles(chromeProfile.Value))
+}
+
+func parsePreferenceFiles(ctx context.Context, chromeProfile utils.ChromeProfilePath, files []string) ([]map[string]string, error) {
+	var results []map[string]string
+
+	contentSettings, err := readContentSettings(ctx, files)
+	if err != nil {
+		return nil, errors.Wrap(err, "reading preferences")
+	}
+
+	for category, exceptions := range contentSettings {
+		for url, exception := range exceptions {
+			var preference Settings
+			if err := json.Unmarshal(exception.raw, &preference); err != nil {
+				log.Printf("Error parsing %s exception for %s: %s", category, url, err)
+				continue
+			}
+
+			primary, secondary := splitPatternPair(url)
+			scheme, host, port := parsePattern(primary)
+			setting, settingName := parseSetting(preference.Setting)
+
+			results = append(results, map[string]string{
+				"category":          category,
+				"url":               url,
+				"primary_pattern":   primary,
+				"secondary_pattern": secondary,
+				"scheme":            scheme,
+				"host":              host,
+				"port":              port,
+				"expiration":        preference.Expiration,
+				"last_modified":     strconv.FormatInt(utils.ChromeTimeToUnix(preference.LastModified), 10),
+				"last_visit":        strconv.FormatInt(utils.ChromeTimeToUnix(preference.LastVisit), 10),
+				"model":             strconv.Itoa(preference.Model),
+				"setting":           setting,
+				"setting_name":      settingName,
+				"source_file":       filepath.Base(exception.sourceFile),
+				"path":              exception.sourceFile,
+				"profile":           filepath.Base(chromeProfile.Value),
+				"profile_name":      chromeProfile.Name,
+				"profile_path":      chromeProfile.Value,
+				"user":              chromeProfile.UserName,
+				"uid":               chromeProfile.UID,
+				"browser_type":      utils.GetChromeBrowserName(chromeProfile.Type),
+				"install_type":      string(chromeProfile.InstallType),
+			})
+		}
 	}
 
 	return results, nil
 }
 
+// NewGoogleChromePreferencesGenerate returns a generate function discovering
+// and reading the profiles on fsys instead of the host filesystem.
+func NewGoogleChromePreferencesGenerate(fsys utils.FileSystem) table.GenerateFunc {
+	return utils.GenerateWithFileSystem(fsys, GoogleChromePreferencesGenerate)
+}
+
 // Per docs generator function has to return an array of map of strings
 func GoogleChromePreferencesGenerate(ctx context.Context, queryContext table.QueryContext) ([]map[string]string, error) {
 	var results []map[string]string
 
-	profileList, err := utils.GetChromeProfilePathList()
+	// Parse the files given explicitly instead of discovering profiles
+	if paths := utils.GetConstraintValues(queryContext, "path"); len(paths) > 0 {
+		for _, path := range paths {
+			profile := utils.ChromeProfileFromPath(ctx, path)
+			res, err := parsePreferenceFiles(utils.WithOwner(ctx, profile.Owner()), profile, []string{path})
+			if err != nil {
+				utils.RecordError("chrome_preferences", profile.UserName, path, err)
+			}
+			results = append(results, res...)
+		}
+		return results, nil
+	}
+
+	profileList, err := utils.GetChromeProfilePathList(ctx, utils.ChromeProfileOptsFromQueryContext(queryContext)...)
 	if err != nil {
 		log.Printf("Error retrieving Chrome profiles list: %s", err)
 		return nil, errors.Wrap(err, "retrieving Chrome profile list")
 	}
 
-	for _, profile := range profileList {
-		res, _ := parsePreferences(ctx, profile)
-		results = append(results, res...)
-	}
-	return results, nil
+	return utils.ScanChromeProfiles(ctx, "chrome_preferences", profileList, parsePreferences), nil
 }
@@ -0,0 +1,469 @@
+package chrome_preferences
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/nachorpaez/osquery-extensions/pkg/registry"
+	"github.com/nachorpaez/osquery-extensions/pkg/utils"
+	"github.com/osquery/osquery-go/plugin/table"
+	"github.com/pkg/errors"
+)
+
+// superMACPath is the name reported for the MAC covering protection.macs
+const superMACPath = "super_mac"
+
+// chromeSeedHex is the seed shipped in the resources of Google Chrome builds.
+// Chromium based browsers built without Google branding use an empty seed.
+const chromeSeedHex = "e748f336d85ea5f9dcdf25d8f347a65b4cdf667600f02df6724a2af18a212d26b788a25086910cf3a90313696871f3dc05823730c91df8ba5c4fd9c884b505a8"
+
+// trackedAtomicPreferences are preferences protected by a single MAC
+var trackedAtomicPreferences = []string{
+	"browser.show_home_button",
+	"default_search_provider_data.template_url_data",
+	"google.services.account_id",
+	"google.services.last_account_id",
+	"google.services.last_username",
+	"homepage",
+	"homepage_is_newtabpage",
+	"media.storage_id_salt",
+	"pinned_tabs",
+	"prefs.preference_reset_time",
+	"safebrowsing.incidents_sent",
+	"search_provider_overrides",
+	"session.restore_on_startup",
+	"session.startup_urls",
+	"software_reporter.prompt_seed",
+	"software_reporter.prompt_version",
+	"software_reporter.reporting",
+}
+
+// trackedSplitPreferences are dictionaries where each entry has its own MAC
+var trackedSplitPreferences = []string{
+	"extensions.settings",
+}
+
+type integrityConfig struct {
+	seed     []byte
+	seedSet  bool
+	deviceID string
+	idSet    bool
+}
+
+type IntegrityOpt func(*integrityConfig)
+
+// WithSeed overrides the seed used as the HMAC key.
+func WithSeed(seed []byte) IntegrityOpt {
+	return func(ic *integrityConfig) {
+		ic.seed = seed
+		ic.seedSet = true
+	}
+}
+
+// WithDeviceID overrides the machine specific ID prepended to each message.
+func WithDeviceID(deviceID string) IntegrityOpt {
+	return func(ic *integrityConfig) {
+		ic.deviceID = deviceID
+		ic.idSet = true
+	}
+}
+
+// seedFor returns the configured seed or the default seed for the browser,
+// false when the seed of the browser isn't known.
+func (ic *integrityConfig) seedFor(browserType utils.ChromeBrowserType) ([]byte, bool) {
+	if ic.seedSet {
+		return ic.seed, true
+	}
+	switch browserType {
+	case utils.GoogleChrome, utils.GoogleChromeBeta, utils.GoogleChromeDev, utils.GoogleChromeCanary:
+		seed, _ := hex.DecodeString(chromeSeedHex)
+		return seed, true
+	case utils.Chromium:
+		return nil, true
+	default:
+		return nil, false
+	}
+}
+
+func init() {
+	registry.Register(registry.Table{
+		Name:      "chrome_preferences_integrity",
+		Columns:   ChromePreferencesIntegrityColumns(),
+		Generate:  ChromePreferencesIntegrityGenerate,
+		Platforms: []string{"darwin", "linux", "windows"},
+	})
+}
+
+func ChromePreferencesIntegrityColumns() []table.ColumnDefinition {
+	return []table.ColumnDefinition{
+		table.TextColumn("path"),
+		table.TextColumn("status"),
+		table.TextColumn("stored_mac"),
+		table.TextColumn("computed_mac"),
+		table.TextColumn("source_file"),
+		table.TextColumn("profile"),
+		table.TextColumn("profile_name"),
+		table.TextColumn("profile_path"),
+		table.TextColumn("user"),
+		table.BigIntColumn("uid"),
+		table.TextColumn("browser_type"),
+		table.TextColumn("install_type"),
+	}
+}
+
+// calculateMAC computes the MAC of a preference value as Chrome's
+// PrefHashCalculator does: HMAC-SHA256(seed, device_id + path + value).
+func calculateMAC(seed []byte, deviceID, path string, value interface{}) string {
+	mac := hmac.New(sha256.New, seed)
+	mac.Write([]byte(deviceID + path + valueAsString(value)))
+	return strings.ToUpper(hex.EncodeToString(mac.Sum(nil)))
+}
+
+// valueAsString serializes a preference value the way Chrome does before
+// hashing it. Missing values are hashed as an empty string and empty
+// dictionaries or lists nested in dictionaries are dropped.
+func valueAsString(value interface{}) string {
+	if value == nil {
+		return ""
+	}
+	if dict, ok := value.(map[string]interface{}); ok {
+		value = removeEmptyEntries(dict)
+	}
+	var buf bytes.Buffer
+	writeJSON(&buf, value)
+	return buf.String()
+}
+
+func removeEmptyEntries(dict map[string]interface{}) map[string]interface{} {
+	result := map[string]interface{}{}
+	for k, v := range dict {
+		switch child := v.(type) {
+		case map[string]interface{}:
+			child = removeEmptyEntries(child)
+			if len(child) == 0 {
+				continue
+			}
+			result[k] = child
+		case []interface{}:
+			if len(child) == 0 {
+				continue
+			}
+			result[k] = child
+		default:
+			result[k] = v
+		}
+	}
+	return result
+}
+
+// writeJSON mirrors base::JSONWriter: sorted keys, no whitespace and
+// doubles with a fraction.
+func writeJSON(buf *bytes.Buffer, value interface{}) {
+	switch v := value.(type) {
+	case nil:
+		buf.WriteString("null")
+	case bool:
+		buf.WriteString(strconv.FormatBool(v))
+	case json.Number:
+		buf.WriteString(formatNumber(v))
+	case string:
+		writeJSONString(buf, v)
+	case []interface{}:
+		buf.WriteByte('[')
+		for i, item := range v {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			writeJSON(buf, item)
+		}
+		buf.WriteByte(']')
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		buf.WriteByte('{')
+		for i, k := range keys {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			writeJSONString(buf, k)
+			buf.WriteByte(':')
+			writeJSON(buf, v[k])
+		}
+		buf.WriteByte('}')
+	}
+}
+
+// formatNumber writes a number as base::JSONWriter does after base::JSONReader
+// read it: numbers written without a fraction or exponent which fit an int
+// stay integers, the others are doubles.
+func formatNumber(n json.Number) string {
+	text := n.String()
+	if !strings.ContainsAny(text, ".eE") {
+		if i, err := strconv.ParseInt(text, 10, 32); err == nil {
+			return strconv.FormatInt(i, 10)
+		}
+	}
+	f, err := n.Float64()
+	if err != nil {
+		return text
+	}
+	return formatDouble(f)
+}
+
+// formatDouble writes the shortest representation of a double, as ECMAScript
+// does, and keeps a fraction on integral values, e.g. 1.0
+func formatDouble(f float64) string {
+	var text string
+	if abs := math.Abs(f); abs == 0 {
+		// -0 is written as 0
+		text = "0"
+	} else if abs >= 1e-6 && abs < 1e21 {
+		text = strconv.FormatFloat(f, 'f', -1, 64)
+	} else {
+		// ECMAScript exponents have no leading zero, 1e-07 is 1e-7
+		mantissa, exponent, _ := strings.Cut(strconv.FormatFloat(f, 'e', -1, 64), "e")
+		text = mantissa + "e" + exponent[:1] + strings.TrimLeft(exponent[1:], "0")
+	}
+	if !strings.ContainsAny(text, ".e") {
+		text += ".0"
+	}
+	return text
+}
+
+func writeJSONString(buf *bytes.Buffer, s string) {
+	buf.WriteByte('"')
+	for _, r := range s {
+		switch r {
+		case '"':
+			buf.WriteString(`\"`)
+		case '\\':
+			buf.WriteString(`\\`)
+		case '\b':
+			buf.WriteString(`\b`)
+		case '\f':
+			buf.WriteString(`\f`)
+		case '\n':
+			buf.WriteString(`\n`)
+		case '\r':
+			buf.WriteString(`\r`)
+		case '\t':
+			buf.WriteString(`\t`)
+		case '<', '\u2028', '\u2029':
+			fmt.Fprintf(buf, `\u%04X`, r)
+		default:
+			if r < 0x20 {
+				fmt.Fprintf(buf, `\u%04X`, r)
+				continue
+			}
+			buf.WriteRune(r)
+		}
+	}
+	buf.WriteByte('"')
+}
+
+// lookupPath returns the value stored at a dotted preference path.
+func lookupPath(prefs map[string]interface{}, path string) (interface{}, bool) {
+	var current interface{} = prefs
+	for _, key := range strings.Split(path, ".") {
+		dict, ok := current.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if current, ok = dict[key]; !ok {
+			return nil, false
+		}
+	}
+	return current, true
+}
+
+// collectMACs flattens protection.macs into a map of preference path to MAC.
+func collectMACs(prefix string, macs map[string]interface{}, output map[string]string) {
+	for k, v := range macs {
+		path := k
+		if prefix != "" {
+			path = prefix + "." + k
+		}
+		switch mac := v.(type) {
+		case string:
+			output[path] = mac
+		case map[string]interface{}:
+			collectMACs(path, mac, output)
+		}
+	}
+}
+
+// macResult holds the MAC stored for a preference path and the one we computed
+type macResult struct {
+	stored   string
+	computed string
+}
+
+// decodePreferences decodes a preferences file keeping numbers as they are
+// written, as they are part of the MACs
+func decodePreferences(fileContent []byte) (map[string]interface{}, error) {
+	decoder := json.NewDecoder(bytes.NewReader(fileContent))
+	decoder.UseNumber()
+	var prefs map[string]interface{}
+	err := decoder.Decode(&prefs)
+	return prefs, err
+}
+
+// verifyPreferences checks every MAC stored in the decoded preferences and
+// reports the tracked preferences which have no MAC at all.
+func verifyPreferences(prefs map[string]interface{}, seed []byte, deviceID string) (map[string]macResult, []string) {
+	storedMACs := map[string]string{}
+	if macs, ok := lookupPath(prefs, "protection.macs"); ok {
+		if dict, ok := macs.(map[string]interface{}); ok {
+			collectMACs("", dict, storedMACs)
+		}
+	}
+
+	computed := map[string]macResult{}
+	for path, stored := range storedMACs {
+		value, _ := lookupPath(prefs, path)
+		computed[path] = macResult{stored: stored, computed: calculateMAC(seed, deviceID, path, value)}
+	}
+
+	if _, hasProtection := prefs["protection"]; hasProtection {
+		macs, _ := lookupPath(prefs, "protection.macs")
+		superMAC, _ := lookupPath(prefs, "protection.super_mac")
+		stored, _ := superMAC.(string)
+		computed[superMACPath] = macResult{stored: stored, computed: calculateMAC(seed, deviceID, "", macs)}
+	}
+
+	// Tracked preferences present in the file without any MAC
+	var missing []string
+	for _, path := range trackedAtomicPreferences {
+		if _, ok := lookupPath(prefs, path); ok {
+			if _, ok := storedMACs[path]; !ok {
+				missing = append(missing, path)
+			}
+		}
+	}
+	for _, splitPath := range trackedSplitPreferences {
+		value, _ := lookupPath(prefs, splitPath)
+		dict, _ := value.(map[string]interface{})
+		for key := range dict {
+			path := splitPath + "." + key
+			if _, ok := storedMACs[path]; !ok {
+				missing = append(missing, path)
+			}
+		}
+	}
+
+	return computed, missing
+}
+
+func verifyProfile(ctx context.Context, chromeProfile utils.ChromeProfilePath, config *integrityConfig) ([]map[string]string, error) {
+	var results []map[string]string
+	seed, seedKnown := config.seedFor(chromeProfile.Type)
+	deviceID := config.deviceID
+	if !config.idSet {
+		id, err := machineDeviceID()
+		if err != nil {
+			log.Printf("Error retrieving device ID: %s", err)
+		}
+		deviceID = id
+	}
+
+	found := false
+	for _, fileName := range []string{utils.ProfilePreferencesFile, utils.SecureProfilePreferencesFile} {
+		prefs, err := utils.ReadParsed(ctx, "chrome_preferences_integrity", filepath.Join(chromeProfile.Value, fileName), decodePreferences)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if utils.IsParseError(err) {
+			return nil, errors.Wrapf(errors.Wrap(err, "unmarshalling preferences file"), "verifying %s file", fileName)
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "reading %s file", fileName)
+		}
+		found = true
+
+		computed, missing := verifyPreferences(prefs, seed, deviceID)
+
+		newRow := func(path, status, stored, calculated string) map[string]string {
+			return map[string]string{
+				"path":         path,
+				"status":       status,
+				"stored_mac":   stored,
+				"computed_mac": calculated,
+				"source_file":  fileName,
+				"profile":      filepath.Base(chromeProfile.Value),
+				"profile_name": chromeProfile.Name,
+				"profile_path": chromeProfile.Value,
+				"user":         chromeProfile.UserName,
+				"uid":          chromeProfile.UID,
+				"browser_type": utils.GetChromeBrowserName(chromeProfile.Type),
+				"install_type": string(chromeProfile.InstallType),
+			}
+		}
+
+		for path, mac := range computed {
+			status := "invalid"
+			switch {
+			case mac.stored == "":
+				status = "missing"
+			case !seedKnown:
+				// The MAC can't be checked without the seed of the browser
+				status = "unknown"
+				mac.computed = ""
+			case strings.EqualFold(mac.stored, mac.computed):
+				status = "valid"
+			}
+			results = append(results, newRow(path, status, mac.stored, mac.computed))
+		}
+		for _, path := range missing {
+			results = append(results, newRow(path, "missing", "", ""))
+		}
+	}
+
+	if !found {
+		return nil, errors.Wrap(fs.ErrNotExist, "no preferences file found")
+	}
+	return results, nil
+}
+
+// NewChromePreferencesIntegrityGenerate returns a generate function using the
+// given seed and device ID overrides. Wrap it with
+// utils.GenerateWithFileSystem to read the profiles from another filesystem.
+func NewChromePreferencesIntegrityGenerate(opts ...IntegrityOpt) table.GenerateFunc {
+	config := &integrityConfig{}
+	for _, opt := range opts {
+		opt(config)
+	}
+
+	return func(ctx context.Context, queryContext table.QueryContext) ([]map[string]string, error) {
+		profileList, err := utils.GetChromeProfilePathList(ctx, utils.ChromeProfileOptsFromQueryContext(queryContext)...)
+		if err != nil {
+			log.Printf("Error retrieving Chrome profiles list: %s", err)
+			return nil, errors.Wrap(err, "retrieving Chrome profile list")
+		}
+
+		verify := func(ctx context.Context, profile utils.ChromeProfilePath) ([]map[string]string, error) {
+			return verifyProfile(ctx, profile, config)
+		}
+		return utils.ScanChromeProfiles(ctx, "chrome_preferences_integrity", profileList, verify), nil
+	}
+}
+
+// Per docs generator function has to return an array of map of strings
+func ChromePreferencesIntegrityGenerate(ctx context.Context, queryContext table.QueryContext) ([]map[string]string, error) {
+	return NewChromePreferencesIntegrityGenerate()(ctx, queryContext)
+}
@@ -0,0 +1,133 @@
+package chrome_preferences
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/nachorpaez/osquery-extensions/pkg/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// test_SecurePreferences_integrity was generated with the seed and device ID
+// below. session.startup_urls and one extension were edited after signing and
+// a forged extension was added without a MAC.
+//
+//go:embed test_SecurePreferences_integrity
+var testSecurePreferencesIntegrity []byte
+
+const (
+	testSeed     = "test-seed"
+	testDeviceID = "S-1-5-21-1111111111-2222222222-3333333333"
+)
+
+func TestVerifyProfile(t *testing.T) {
+	tempDir := t.TempDir()
+	err := os.WriteFile(filepath.Join(tempDir, utils.SecureProfilePreferencesFile), testSecurePreferencesIntegrity, 0600)
+	require.NoError(t, err)
+
+	chromeProfile := utils.ChromeProfilePath{
+		UserName: "user1",
+		UID:      "1001",
+		Value:    tempDir,
+		Type:     utils.GoogleChrome,
+	}
+	config := &integrityConfig{}
+	WithSeed([]byte(testSeed))(config)
+	WithDeviceID(testDeviceID)(config)
+
+	results, err := verifyProfile(context.Background(), chromeProfile, config)
+	require.NoError(t, err)
+
+	statuses := map[string]string{}
+	for _, row := range results {
+		assert.Equal(t, utils.SecureProfilePreferencesFile, row["source_file"])
+		assert.Equal(t, "user1", row["user"])
+		assert.Equal(t, "chrome", row["browser_type"])
+		statuses[row["path"]] = row["status"]
+	}
+
+	assert.Equal(t, map[string]string{
+		"homepage":                   "valid",
+		"pinned_tabs":                "valid",
+		"session.restore_on_startup": "valid",
+		"session.startup_urls":       "invalid",
+		"browser.show_home_button":   "missing",
+		"extensions.settings.aeblfdkhhhdcdjpifhhbdiojplfjncoa": "valid",
+		"extensions.settings.dgjhfomjieaadpoljlnidmbgkdffpack": "invalid",
+		"extensions.settings.nkbihfbeogaeaoehlefnkodbefgpgknn": "missing",
+		superMACPath: "valid",
+	}, statuses)
+}
+
+func TestVerifyProfileWrongDeviceID(t *testing.T) {
+	tempDir := t.TempDir()
+	err := os.WriteFile(filepath.Join(tempDir, utils.SecureProfilePreferencesFile), testSecurePreferencesIntegrity, 0600)
+	require.NoError(t, err)
+
+	config := &integrityConfig{}
+	WithSeed([]byte(testSeed))(config)
+	WithDeviceID("S-1-5-21-0-0-0")(config)
+
+	results, err := verifyProfile(context.Background(), utils.ChromeProfilePath{Value: tempDir}, config)
+	require.NoError(t, err)
+	for _, row := range results {
+		assert.NotEqual(t, "valid", row["status"], row["path"])
+	}
+}
+
+func TestValueAsString(t *testing.T) {
+	var value interface{}
+	decoder := json.NewDecoder(strings.NewReader(`{"b":[],"a":{"c":{}},"d":1.0,"e":"<x>","f":[{}],"g":null}`))
+	decoder.UseNumber()
+	require.NoError(t, decoder.Decode(&value))
+
+	assert.Equal(t, `{"d":1.0,"e":"\u003Cx>","f":[{}],"g":null}`, valueAsString(value))
+	assert.Equal(t, "", valueAsString(nil))
+}
+
+func TestFormatNumber(t *testing.T) {
+	for text, expected := range map[string]string{
+		"5":          "5",
+		"-12":        "-12",
+		"-0":         "0",
+		"1.0":        "1.0",
+		"-0.0":       "0.0",
+		"0.5":        "0.5",
+		"1e2":        "100.0",
+		"3000000000": "3000000000.0",
+		"1e21":       "1e+21",
+		"1.5e-7":     "1.5e-7",
+		"0.000001":   "0.000001",
+	} {
+		assert.Equal(t, expected, formatNumber(json.Number(text)), text)
+	}
+}
+
+func TestVerifyProfileUnknownSeed(t *testing.T) {
+	tempDir := t.TempDir()
+	err := os.WriteFile(filepath.Join(tempDir, utils.SecureProfilePreferencesFile), testSecurePreferencesIntegrity, 0600)
+	require.NoError(t, err)
+
+	// The seed of Brave isn't known, its MACs are neither valid nor invalid
+	config := &integrityConfig{}
+	WithDeviceID(testDeviceID)(config)
+	results, err := verifyProfile(context.Background(), utils.ChromeProfilePath{Value: tempDir, Type: utils.Brave}, config)
+	require.NoError(t, err)
+	statuses := map[string]string{}
+	for _, row := range results {
+		statuses[row["path"]] = row["status"]
+		if row["status"] == "unknown" {
+			assert.NotEmpty(t, row["stored_mac"], row["path"])
+			assert.Empty(t, row["computed_mac"], row["path"])
+		}
+	}
+	assert.Equal(t, "unknown", statuses["homepage"])
+	assert.Equal(t, "unknown", statuses[superMACPath])
+	assert.Equal(t, "missing", statuses["browser.show_home_button"])
+}
@@ -5,15 +5,21 @@ import (
 	_ "embed"
 	"os"
 	"path/filepath"
+	"runtime"
 	"testing"
+	"testing/fstest"
 
 	"github.com/nachorpaez/osquery-extensions/pkg/utils"
+	"github.com/osquery/osquery-go/plugin/table"
 	"github.com/stretchr/testify/assert"
 )
 
 //go:embed test_Preferences
 var testPreferences []byte
 
+//go:embed test_SecurePreferences
+var testSecurePreferences []byte
+
 func TestParsePreferences(t *testing.T) {
 	// Create a temporary directory for testing
 	tempDir := t.TempDir()
@@ -25,73 +31,434 @@ func TestParsePreferences(t *testing.T) {
 
 	// Build a ChromeProfilePath to reflect the new function signature
 	chromeProfile := utils.ChromeProfilePath{
-		UserName: "user1",            // Matches old "User" field
-		Value:    tempDir,            // The containing directory
-		Type:     utils.GoogleChrome, // Arbitrary choice of browser type
+		UserName:    "user1",            // Matches old "User" field
+		Value:       tempDir,            // The containing directory
+		Type:        utils.GoogleChrome, // Arbitrary choice of browser type
+		UID:         "1001",
+		Name:        "Personal",
+		InstallType: utils.NativeInstall,
 	}
 
 	results, err := parsePreferences(context.Background(), chromeProfile)
 	assert.NoError(t, err)
-	assert.Len(t, results, 5)
+	assert.Len(t, results, 9)
 
 	expectedRows := []map[string]string{
 		{
-			"category":      "geolocation",
-			"url":           "https://test.com:443,*",
-			"expiration":    "",
-			"last_modified": "13359398812209250",
-			"model":         "0",
-			"setting":       "2",
-			"profile_path":  tempDir,
-			"user":          "user1",
-			"browser_type":  "chrome", // from utils.GetChromeBrowserName(utils.GoogleChrome)
+			"category":          "geolocation",
+			"url":               "https://test.com:443,*",
+			"primary_pattern":   "https://test.com:443",
+			"secondary_pattern": "*",
+			"scheme":            "https",
+			"host":              "test.com",
+			"port":              "443",
+			"expiration":        "",
+			"last_modified":     "1714925212",
+			"last_visit":        "0",
+			"model":             "0",
+			"setting":           "2",
+			"source_file":       "Preferences",
+			"path":              filepath.Join(tempDir, "Preferences"),
+			"setting_name":      "block",
+			"profile":           filepath.Base(tempDir),
+			"profile_name":      "Personal",
+			"profile_path":      tempDir,
+			"user":              "user1",
+			"uid":               "1001",
+			"browser_type":      "chrome", // from utils.GetChromeBrowserName(utils.GoogleChrome)
+			"install_type":      "native",
+		},
+		{
+			"category":          "notifications",
+			"url":               "https://meet.google.com:443,*",
+			"primary_pattern":   "https://meet.google.com:443",
+			"secondary_pattern": "*",
+			"scheme":            "https",
+			"host":              "meet.google.com",
+			"port":              "443",
+			"expiration":        "",
+			"last_modified":     "1712775205",
+			"last_visit":        "0",
+			"model":             "0",
+			"setting":           "1",
+			"source_file":       "Preferences",
+			"path":              filepath.Join(tempDir, "Preferences"),
+			"setting_name":      "allow",
+			"profile":           filepath.Base(tempDir),
+			"profile_name":      "Personal",
+			"profile_path":      tempDir,
+			"user":              "user1",
+			"uid":               "1001",
+			"browser_type":      "chrome",
+			"install_type":      "native",
+		},
+		{
+			"category":          "media_stream_camera",
+			"url":               "https://meet.google.com:443,*",
+			"primary_pattern":   "https://meet.google.com:443",
+			"secondary_pattern": "*",
+			"scheme":            "https",
+			"host":              "meet.google.com",
+			"port":              "443",
+			"expiration":        "",
+			"last_modified":     "1712775219",
+			"last_visit":        "1712534400",
+			"model":             "0",
+			"setting":           "1",
+			"source_file":       "Preferences",
+			"path":              filepath.Join(tempDir, "Preferences"),
+			"setting_name":      "allow",
+			"profile":           filepath.Base(tempDir),
+			"profile_name":      "Personal",
+			"profile_path":      tempDir,
+			"user":              "user1",
+			"uid":               "1001",
+			"browser_type":      "chrome",
+			"install_type":      "native",
 		},
 		{
-			"category":      "notifications",
-			"url":           "https://meet.google.com:443,*",
-			"expiration":    "",
-			"last_modified": "13357248805228331",
-			"model":         "0",
-			"setting":       "1",
-			"profile_path":  tempDir,
-			"user":          "user1",
-			"browser_type":  "chrome",
+			"category":          "media_stream_mic",
+			"url":               "https://meet.google.com:443,*",
+			"primary_pattern":   "https://meet.google.com:443",
+			"secondary_pattern": "*",
+			"scheme":            "https",
+			"host":              "meet.google.com",
+			"port":              "443",
+			"expiration":        "",
+			"last_modified":     "1712775197",
+			"last_visit":        "1712534400",
+			"model":             "1",
+			"setting":           "1",
+			"source_file":       "Preferences",
+			"path":              filepath.Join(tempDir, "Preferences"),
+			"setting_name":      "allow",
+			"profile":           filepath.Base(tempDir),
+			"profile_name":      "Personal",
+			"profile_path":      tempDir,
+			"user":              "user1",
+			"uid":               "1001",
+			"browser_type":      "chrome",
+			"install_type":      "native",
 		},
 		{
-			"category":      "media_stream_camera",
-			"url":           "https://meet.google.com:443,*",
-			"expiration":    "",
-			"last_modified": "13357248819111798",
-			"model":         "0",
-			"setting":       "1",
-			"profile_path":  tempDir,
-			"user":          "user1",
-			"browser_type":  "chrome",
+			"category":          "popups",
+			"url":               "https://www.digicert.com:443,*",
+			"primary_pattern":   "https://www.digicert.com:443",
+			"secondary_pattern": "*",
+			"scheme":            "https",
+			"host":              "www.digicert.com",
+			"port":              "443",
+			"expiration":        "",
+			"last_modified":     "0",
+			"last_visit":        "0",
+			"model":             "0",
+			"setting":           "1",
+			"source_file":       "Preferences",
+			"path":              filepath.Join(tempDir, "Preferences"),
+			"setting_name":      "allow",
+			"profile":           filepath.Base(tempDir),
+			"profile_name":      "Personal",
+			"profile_path":      tempDir,
+			"user":              "user1",
+			"uid":               "1001",
+			"browser_type":      "chrome",
+			"install_type":      "native",
 		},
 		{
-			"category":      "media_stream_mic",
-			"url":           "https://meet.google.com:443,*",
-			"expiration":    "",
-			"last_modified": "13357248797611836",
-			"model":         "1",
-			"setting":       "1",
-			"profile_path":  tempDir,
-			"user":          "user1",
-			"browser_type":  "chrome",
+			"category":          "clipboard",
+			"url":               "[*.]example.com,*",
+			"primary_pattern":   "[*.]example.com",
+			"secondary_pattern": "*",
+			"scheme":            "",
+			"host":              "[*.]example.com",
+			"port":              "",
+			"expiration":        "",
+			"last_modified":     "1715526400",
+			"last_visit":        "0",
+			"model":             "0",
+			"setting":           "1",
+			"source_file":       "Preferences",
+			"path":              filepath.Join(tempDir, "Preferences"),
+			"setting_name":      "allow",
+			"profile":           filepath.Base(tempDir),
+			"profile_name":      "Personal",
+			"profile_path":      tempDir,
+			"user":              "user1",
+			"uid":               "1001",
+			"browser_type":      "chrome",
+			"install_type":      "native",
 		},
 		{
-			"category":      "popups",
-			"url":           "https://www.digicert.com:443,*",
-			"expiration":    "",
-			"last_modified": "",
-			"model":         "0",
-			"setting":       "1",
-			"profile_path":  tempDir,
-			"user":          "user1",
-			"browser_type":  "chrome",
+			"category":          "client_hints",
+			"url":               "https://www.google.com:443,*",
+			"primary_pattern":   "https://www.google.com:443",
+			"secondary_pattern": "*",
+			"scheme":            "https",
+			"host":              "www.google.com",
+			"port":              "443",
+			"expiration":        "",
+			"last_modified":     "1713526400",
+			"last_visit":        "0",
+			"model":             "0",
+			"setting":           "",
+			"source_file":       "Preferences",
+			"path":              filepath.Join(tempDir, "Preferences"),
+			"setting_name":      "",
+			"profile":           filepath.Base(tempDir),
+			"profile_name":      "Personal",
+			"profile_path":      tempDir,
+			"user":              "user1",
+			"uid":               "1001",
+			"browser_type":      "chrome",
+			"install_type":      "native",
+		},
+		{
+			"category":          "cookies",
+			"url":               "https://accounts.google.com:443,*",
+			"primary_pattern":   "https://accounts.google.com:443",
+			"secondary_pattern": "*",
+			"scheme":            "https",
+			"host":              "accounts.google.com",
+			"port":              "443",
+			"expiration":        "",
+			"last_modified":     "0",
+			"last_visit":        "0",
+			"model":             "0",
+			"setting":           "4",
+			"source_file":       "Preferences",
+			"path":              filepath.Join(tempDir, "Preferences"),
+			"setting_name":      "session_only",
+			"profile":           filepath.Base(tempDir),
+			"profile_name":      "Personal",
+			"profile_path":      tempDir,
+			"user":              "user1",
+			"uid":               "1001",
+			"browser_type":      "chrome",
+			"install_type":      "native",
+		},
+		{
+			"category":          "javascript",
+			"url":               "http://localhost:8080,*",
+			"primary_pattern":   "http://localhost:8080",
+			"secondary_pattern": "*",
+			"scheme":            "http",
+			"host":              "localhost",
+			"port":              "8080",
+			"expiration":        "13370000000000000",
+			"last_modified":     "0",
+			"last_visit":        "0",
+			"model":             "0",
+			"setting":           "2",
+			"source_file":       "Preferences",
+			"path":              filepath.Join(tempDir, "Preferences"),
+			"setting_name":      "block",
+			"profile":           filepath.Base(tempDir),
+			"profile_name":      "Personal",
+			"profile_path":      tempDir,
+			"user":              "user1",
+			"uid":               "1001",
+			"browser_type":      "chrome",
+			"install_type":      "native",
 		},
 	}
 
 	// Use ElementsMatch if the order doesn't matter; otherwise use Equal
 	assert.ElementsMatch(t, expectedRows, results)
 }
+
+func TestParsePattern(t *testing.T) {
+	tests := []struct {
+		pattern string
+		scheme  string
+		host    string
+		port    string
+	}{
+		{"https://meet.google.com:443", "https", "meet.google.com", "443"},
+		{"[*.]example.com", "", "[*.]example.com", ""},
+		{"http://[::1]:8080", "http", "[::1]", "8080"},
+		{"http://[::1]", "http", "[::1]", ""},
+		{"file:///home/user/index.html", "file", "", ""},
+		{"*", "", "*", ""},
+	}
+
+	for _, tt := range tests {
+		scheme, host, port := parsePattern(tt.pattern)
+		assert.Equal(t, tt.scheme, scheme, tt.pattern)
+		assert.Equal(t, tt.host, host, tt.pattern)
+		assert.Equal(t, tt.port, port, tt.pattern)
+	}
+}
+
+func TestParsePreferencesMergesSecurePreferences(t *testing.T) {
+	tempDir := t.TempDir()
+	err := os.WriteFile(filepath.Join(tempDir, utils.ProfilePreferencesFile), testPreferences, 0600)
+	assert.NoError(t, err)
+	err = os.WriteFile(filepath.Join(tempDir, utils.SecureProfilePreferencesFile), testSecurePreferences, 0600)
+	assert.NoError(t, err)
+
+	chromeProfile := utils.ChromeProfilePath{
+		UserName: "user1",
+		UID:      "1001",
+		Value:    tempDir,
+		Type:     utils.GoogleChrome,
+	}
+
+	results, err := parsePreferences(context.Background(), chromeProfile)
+	assert.NoError(t, err)
+	assert.Len(t, results, 10)
+
+	rowsByURL := map[string]map[string]string{}
+	for _, row := range results {
+		rowsByURL[row["category"]+"|"+row["url"]] = row
+	}
+
+	// Secure Preferences overrides the value stored in Preferences
+	geolocation := rowsByURL["geolocation|https://test.com:443,*"]
+	assert.Equal(t, "Secure Preferences", geolocation["source_file"])
+	assert.Equal(t, "allow", geolocation["setting_name"])
+	assert.Equal(t, "1715526400", geolocation["last_modified"])
+
+	// Entries only present in Secure Preferences are returned too
+	assert.Equal(t, "Secure Preferences", rowsByURL["notifications|https://suspicious.example:443,*"]["source_file"])
+	assert.Equal(t, "Preferences", rowsByURL["notifications|https://meet.google.com:443,*"]["source_file"])
+}
+
+func TestParsePreferencesSecurePreferencesOnly(t *testing.T) {
+	tempDir := t.TempDir()
+	err := os.WriteFile(filepath.Join(tempDir, utils.SecureProfilePreferencesFile), testSecurePreferences, 0600)
+	assert.NoError(t, err)
+
+	chromeProfile := utils.ChromeProfilePath{
+		UserName: "user1",
+		UID:      "1001",
+		Value:    tempDir,
+		Type:     utils.GoogleChrome,
+	}
+
+	results, err := parsePreferences(context.Background(), chromeProfile)
+	assert.NoError(t, err)
+	assert.Len(t, results, 2)
+	for _, row := range results {
+		assert.Equal(t, "Secure Preferences", row["source_file"])
+	}
+
+	_, err = parsePreferences(context.Background(), utils.ChromeProfilePath{Value: t.TempDir()})
+	assert.Error(t, err)
+}
+
+func TestGoogleChromePreferencesGenerateWithPath(t *testing.T) {
+	// A file copied off a machine, outside of any home directory
+	preferencesFile := filepath.Join(t.TempDir(), "Preferences")
+	err := os.WriteFile(preferencesFile, testPreferences, 0600)
+	assert.NoError(t, err)
+
+	queryContext := table.QueryContext{
+		Constraints: map[string]table.ConstraintList{
+			"path": {Constraints: []table.Constraint{
+				{Operator: table.OperatorEquals, Expression: preferencesFile},
+			}},
+		},
+	}
+
+	results, err := GoogleChromePreferencesGenerate(context.Background(), queryContext)
+	assert.NoError(t, err)
+	assert.Len(t, results, 9)
+	for _, row := range results {
+		assert.Equal(t, preferencesFile, row["path"])
+		assert.Equal(t, "", row["user"])
+		assert.Equal(t, "", row["browser_type"])
+	}
+}
+
+func TestGoogleChromePreferencesGenerateWithRoot(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("fixture uses the Linux directory layout")
+	}
+
+	// Directory tree of a mounted Linux image
+	root := t.TempDir()
+	profileDir := filepath.Join(root, "home", "alice", ".config", "google-chrome", "Default")
+	assert.NoError(t, os.MkdirAll(profileDir, 0755))
+	assert.NoError(t, os.WriteFile(filepath.Join(profileDir, "Preferences"), testPreferences, 0600))
+
+	utils.SetRootDir(root)
+	t.Cleanup(func() { utils.SetRootDir("") })
+
+	results, err := GoogleChromePreferencesGenerate(context.Background(), table.QueryContext{})
+	assert.NoError(t, err)
+	assert.Len(t, results, 9)
+	for _, row := range results {
+		assert.Equal(t, filepath.Join(profileDir, "Preferences"), row["path"])
+		assert.Equal(t, "alice", row["user"])
+		assert.Equal(t, "chrome", row["browser_type"])
+	}
+}
+
+func TestGoogleChromePreferencesGenerateRecordsErrors(t *testing.T) {
+	preferencesFile := filepath.Join(t.TempDir(), "Preferences")
+	assert.NoError(t, os.WriteFile(preferencesFile, []byte("{not json"), 0600))
+
+	queryContext := table.QueryContext{
+		Constraints: map[string]table.ConstraintList{
+			"path": {Constraints: []table.Constraint{
+				{Operator: table.OperatorEquals, Expression: preferencesFile},
+			}},
+		},
+	}
+
+	results, err := GoogleChromePreferencesGenerate(context.Background(), queryContext)
+	assert.NoError(t, err)
+	assert.Empty(t, results)
+
+	errs := utils.RecentErrors()
+	if assert.NotEmpty(t, errs) {
+		last := errs[len(errs)-1]
+		assert.Equal(t, "chrome_preferences", last.Table)
+		assert.Equal(t, preferencesFile, last.Path)
+		assert.Equal(t, utils.ErrorClassParseError, last.Class)
+	}
+}
+
+func TestGoogleChromePreferencesGenerateOnFileSystem(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("fixture uses the Linux directory layout")
+	}
+
+	// Two users, three browsers
+	generate := NewGoogleChromePreferencesGenerate(utils.NewFileSystem(fstest.MapFS{
+		"etc/passwd": {Data: []byte("alice:x:1000:1000::/home/alice:/bin/bash\nbob:x:1001:1001::/home/bob:/bin/bash\n")},
+		"home/alice/.config/google-chrome/Default/Preferences":             {Data: testPreferences},
+		"home/alice/.config/chromium/Profile 1/Preferences":                {Data: testPreferences},
+		"home/bob/.config/BraveSoftware/Brave-Browser/Default/Preferences": {Data: testPreferences},
+	}))
+
+	results, err := generate(context.Background(), table.QueryContext{})
+	assert.NoError(t, err)
+	counts := map[string]int{}
+	for _, row := range results {
+		counts[row["user"]+"/"+row["uid"]+"/"+row["browser_type"]+"/"+row["profile"]]++
+	}
+	assert.Equal(t, map[string]int{
+		"alice/1000/chrome/Default":     9,
+		"alice/1000/chromium/Profile 1": 9,
+		"bob/1001/brave/Default":        9,
+	}, counts)
+
+	// Constraints are pushed down into discovery
+	queryContext := table.QueryContext{
+		Constraints: map[string]table.ConstraintList{
+			"user": {Constraints: []table.Constraint{
+				{Operator: table.OperatorEquals, Expression: "alice"},
+			}},
+			"browser_type": {Constraints: []table.Constraint{
+				{Operator: table.OperatorEquals, Expression: "chromium"},
+			}},
+		},
+	}
+	results, err = generate(context.Background(), queryContext)
+	assert.NoError(t, err)
+	assert.Len(t, results, 9)
+	for _, row := range results {
+		assert.Equal(t, filepath.FromSlash("/home/alice/.config/chromium/Profile 1/Preferences"), row["path"])
+	}
+}
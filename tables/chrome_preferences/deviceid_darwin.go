@@ -0,0 +1,26 @@
+//go:build darwin
+
+package chrome_preferences
+
+import (
+	"os/exec"
+	"regexp"
+
+	"github.com/pkg/errors"
+)
+
+var platformUUID = regexp.MustCompile(`"IOPlatformUUID" = "([0-9A-Fa-f-]+)"`)
+
+// machineDeviceID returns the machine specific ID used by Chrome to compute
+// preference MACs. On macOS this is the hardware IOPlatformUUID.
+func machineDeviceID() (string, error) {
+	out, err := exec.Command("/usr/sbin/ioreg", "-rd1", "-c", "IOPlatformExpertDevice").Output()
+	if err != nil {
+		return "", errors.Wrap(err, "running ioreg")
+	}
+	match := platformUUID.FindSubmatch(out)
+	if match == nil {
+		return "", errors.New("IOPlatformUUID not found")
+	}
+	return string(match[1]), nil
+}
@@ -0,0 +1,9 @@
+//go:build !windows && !darwin
+
+package chrome_preferences
+
+// machineDeviceID returns the machine specific ID used by Chrome to compute
+// preference MACs. Chrome does not use a device ID on Linux.
+func machineDeviceID() (string, error) {
+	return "", nil
+}
@@ -0,0 +1,32 @@
+//go:build windows
+
+package chrome_preferences
+
+import (
+	"strings"
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+// machineDeviceID returns the machine specific ID used by Chrome to compute
+// preference MACs. On Windows this is the machine SID without the trailing RID.
+func machineDeviceID() (string, error) {
+	computerName, err := syscall.ComputerName()
+	if err != nil {
+		return "", errors.Wrap(err, "retrieving computer name")
+	}
+	sid, _, _, err := syscall.LookupSID("", computerName)
+	if err != nil {
+		return "", errors.Wrap(err, "looking up machine SID")
+	}
+	sidString, err := sid.String()
+	if err != nil {
+		return "", errors.Wrap(err, "converting machine SID")
+	}
+	// LookupSID may return the SID of an account, drop the RID in that case
+	if parts := strings.Split(sidString, "-"); len(parts) > 7 {
+		sidString = strings.Join(parts[:7], "-")
+	}
+	return sidString, nil
+}
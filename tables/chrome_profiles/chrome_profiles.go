@@ -0,0 +1,111 @@
+package chrome_profiles
+
+import (
+	"context"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/nachorpaez/osquery-extensions/pkg/registry"
+	"github.com/nachorpaez/osquery-extensions/pkg/utils"
+	"github.com/osquery/osquery-go/plugin/table"
+	"github.com/pkg/errors"
+)
+
+func init() {
+	registry.Register(registry.Table{
+		Name:      "chrome_profiles",
+		Columns:   ChromeProfilesColumns(),
+		Generate:  ChromeProfilesGenerate,
+		Platforms: []string{"darwin", "linux", "windows"},
+	})
+}
+
+func ChromeProfilesColumns() []table.ColumnDefinition {
+	return []table.ColumnDefinition{
+		table.TextColumn("browser_type"),
+		table.TextColumn("install_type"),
+		table.TextColumn("browser_version"),
+		table.TextColumn("profile"),
+		table.TextColumn("profile_name"),
+		table.TextColumn("user"),
+		table.BigIntColumn("uid"),
+		table.TextColumn("gaia_name"),
+		table.TextColumn("email"),
+		table.IntegerColumn("is_managed"),
+		table.TextColumn("hosted_domain"),
+		table.TextColumn("avatar_icon"),
+		table.IntegerColumn("last_used"),
+		table.BigIntColumn("active_time"),
+		table.IntegerColumn("is_ephemeral"),
+		table.TextColumn("path"),
+	}
+}
+
+// parseProfile describes a profile with its entry in the Local State of the
+// user data directory. Profiles missing from Local State only get the
+// columns known from their path.
+func parseProfile(ctx context.Context, chromeProfile utils.ChromeProfilePath) ([]map[string]string, error) {
+	profileDir := filepath.Base(chromeProfile.Value)
+
+	var info utils.ChromeProfileInfo
+	var lastUsed bool
+	localState, err := utils.ReadChromeLocalState(ctx, chromeProfile.UserDataDir)
+	switch {
+	case err == nil:
+		info = localState.Profile.InfoCache[profileDir]
+		lastUsed = localState.Profile.LastUsed == profileDir
+	case os.IsNotExist(err):
+	case utils.IsParseError(err):
+		log.Printf("Error parsing Local State of %s: %s", chromeProfile.UserDataDir, err)
+		return nil, errors.Wrap(err, "unmarshalling Local State")
+	default:
+		log.Printf("Error reading Local State of %s: %s", chromeProfile.UserDataDir, err)
+		return nil, errors.Wrap(err, "reading Local State")
+	}
+
+	hostedDomain := info.HostedDomain
+	if !info.IsManaged() {
+		hostedDomain = ""
+	}
+	activeTime := ""
+	if info.ActiveTime > 0 {
+		activeTime = strconv.FormatInt(int64(info.ActiveTime), 10)
+	}
+
+	return []map[string]string{{
+		"browser_type":    utils.GetChromeBrowserName(chromeProfile.Type),
+		"install_type":    string(chromeProfile.InstallType),
+		"browser_version": utils.ReadChromeLastVersion(ctx, chromeProfile.UserDataDir),
+		"profile":         profileDir,
+		"profile_name":    chromeProfile.Name,
+		"user":            chromeProfile.UserName,
+		"uid":             chromeProfile.UID,
+		"gaia_name":       info.GaiaName,
+		"email":           info.UserName,
+		"is_managed":      strconv.Itoa(utils.Btoi(info.IsManaged())),
+		"hosted_domain":   hostedDomain,
+		"avatar_icon":     info.AvatarIcon,
+		"last_used":       strconv.Itoa(utils.Btoi(lastUsed)),
+		"active_time":     activeTime,
+		"is_ephemeral":    strconv.Itoa(utils.Btoi(info.IsEphemeral)),
+		"path":            chromeProfile.Value,
+	}}, nil
+}
+
+// NewChromeProfilesGenerate returns a generate function discovering and
+// reading the profiles on fsys instead of the host filesystem.
+func NewChromeProfilesGenerate(fsys utils.FileSystem) table.GenerateFunc {
+	return utils.GenerateWithFileSystem(fsys, ChromeProfilesGenerate)
+}
+
+func ChromeProfilesGenerate(ctx context.Context, queryContext table.QueryContext) ([]map[string]string, error) {
+	profileList, err := utils.GetChromeProfilePathList(ctx, utils.ChromeProfileOptsFromQueryContext(queryContext)...)
+	if err != nil {
+		log.Printf("Error retrieving Chrome profiles list: %s", err)
+		return nil, errors.Wrap(err, "retrieving Chrome profile list")
+	}
+
+	return utils.ScanChromeProfiles(ctx, "chrome_profiles", profileList, parseProfile), nil
+}
@@ -0,0 +1,133 @@
+package chrome_profiles
+
+import (
+	"context"
+	_ "embed"
+	"runtime"
+	"testing"
+	"testing/fstest"
+
+	"github.com/nachorpaez/osquery-extensions/pkg/utils"
+	"github.com/osquery/osquery-go/plugin/table"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+//go:embed test_LocalState
+var testLocalState []byte
+
+func TestChromeProfilesGenerateOnFileSystem(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("fixture uses the Linux directory layout")
+	}
+
+	fsys := fstest.MapFS{
+		"etc/passwd": {Data: []byte("alice:x:1000:1000::/home/alice:/bin/bash\nbob:x:1001:1001::/home/bob:/bin/bash\n")},
+		"home/alice/.config/google-chrome/Local State":                {Data: testLocalState},
+		"home/alice/.config/google-chrome/Last Version":               {Data: []byte("130.0.6723.58\n")},
+		"home/alice/.config/google-chrome/Default/Preferences":        {Data: []byte("{}")},
+		"home/alice/.config/google-chrome/Profile 1/Preferences":      {Data: []byte("{}")},
+		"home/alice/.config/google-chrome/Profile 2/Preferences":      {Data: []byte("{}")},
+		"home/alice/.config/google-chrome/System Profile/Preferences": {Data: []byte("{}")},
+		// No Local State, the profile is only known from its directory
+		"home/bob/.config/BraveSoftware/Brave-Browser/Default/Preferences": {Data: []byte("{}")},
+	}
+
+	results, err := NewChromeProfilesGenerate(utils.NewFileSystem(fsys))(context.Background(), table.QueryContext{})
+	require.NoError(t, err)
+
+	assert.ElementsMatch(t, []map[string]string{
+		{
+			"browser_type":    "chrome",
+			"install_type":    "native",
+			"browser_version": "130.0.6723.58",
+			"profile":         "Default",
+			"profile_name":    "Personal",
+			"user":            "alice",
+			"uid":             "1000",
+			"gaia_name":       "Alice Example",
+			"email":           "alice@gmail.com",
+			"is_managed":      "0",
+			"hosted_domain":   "",
+			"avatar_icon":     "chrome://theme/IDR_PROFILE_AVATAR_26",
+			"last_used":       "0",
+			"active_time":     "1729171200",
+			"is_ephemeral":    "0",
+			"path":            "/home/alice/.config/google-chrome/Default",
+		},
+		{
+			"browser_type":    "chrome",
+			"install_type":    "native",
+			"browser_version": "130.0.6723.58",
+			"profile":         "Profile 1",
+			"profile_name":    "Work",
+			"user":            "alice",
+			"uid":             "1000",
+			"gaia_name":       "Alice Example",
+			"email":           "alice@example.com",
+			"is_managed":      "1",
+			"hosted_domain":   "example.com",
+			"avatar_icon":     "chrome://theme/IDR_PROFILE_AVATAR_4",
+			"last_used":       "1",
+			"active_time":     "1729257600",
+			"is_ephemeral":    "0",
+			"path":            "/home/alice/.config/google-chrome/Profile 1",
+		},
+		{
+			"browser_type":    "chrome",
+			"install_type":    "native",
+			"browser_version": "130.0.6723.58",
+			"profile":         "Profile 2",
+			"profile_name":    "Guest browsing",
+			"user":            "alice",
+			"uid":             "1000",
+			"gaia_name":       "",
+			"email":           "",
+			"is_managed":      "0",
+			"hosted_domain":   "",
+			"avatar_icon":     "chrome://theme/IDR_PROFILE_AVATAR_0",
+			"last_used":       "0",
+			"active_time":     "",
+			"is_ephemeral":    "1",
+			"path":            "/home/alice/.config/google-chrome/Profile 2",
+		},
+		{
+			"browser_type":    "brave",
+			"install_type":    "native",
+			"browser_version": "",
+			"profile":         "Default",
+			"profile_name":    "",
+			"user":            "bob",
+			"uid":             "1001",
+			"gaia_name":       "",
+			"email":           "",
+			"is_managed":      "0",
+			"hosted_domain":   "",
+			"avatar_icon":     "",
+			"last_used":       "0",
+			"active_time":     "",
+			"is_ephemeral":    "0",
+			"path":            "/home/bob/.config/BraveSoftware/Brave-Browser/Default",
+		},
+	}, results)
+}
+
+func TestChromeProfilesInvalidLocalState(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("fixture uses the Linux directory layout")
+	}
+
+	fsys := fstest.MapFS{
+		"etc/passwd": {Data: []byte("alice:x:1000:1000::/home/alice:/bin/bash\n")},
+		"home/alice/.config/google-chrome/Local State":         {Data: []byte("{")},
+		"home/alice/.config/google-chrome/Default/Preferences": {Data: []byte("{}")},
+	}
+	ctx := utils.WithFileSystem(context.Background(), utils.NewFileSystem(fsys))
+
+	profiles, err := utils.GetChromeProfilePathList(ctx)
+	require.NoError(t, err)
+	require.Len(t, profiles, 1)
+
+	_, err = parseProfile(ctx, profiles[0])
+	assert.ErrorContains(t, err, "unmarshalling Local State")
+}
@@ -0,0 +1,45 @@
+package extension_errors
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/nachorpaez/osquery-extensions/pkg/registry"
+	"github.com/nachorpaez/osquery-extensions/pkg/utils"
+	"github.com/osquery/osquery-go/plugin/table"
+)
+
+func init() {
+	registry.Register(registry.Table{
+		Name:     "osquery_extension_errors",
+		Columns:  ExtensionErrorsColumns(),
+		Generate: ExtensionErrorsGenerate,
+	})
+}
+
+func ExtensionErrorsColumns() []table.ColumnDefinition {
+	return []table.ColumnDefinition{
+		table.TextColumn("table_name"),
+		table.TextColumn("user"),
+		table.TextColumn("path"),
+		table.TextColumn("error_class"),
+		table.TextColumn("message"),
+		table.BigIntColumn("timestamp"),
+	}
+}
+
+// Per docs generator function has to return an array of map of strings
+func ExtensionErrorsGenerate(ctx context.Context, queryContext table.QueryContext) ([]map[string]string, error) {
+	var results []map[string]string
+	for _, e := range utils.RecentErrors() {
+		results = append(results, map[string]string{
+			"table_name":  e.Table,
+			"user":        e.User,
+			"path":        e.Path,
+			"error_class": e.Class,
+			"message":     e.Message,
+			"timestamp":   strconv.FormatInt(e.Timestamp.Unix(), 10),
+		})
+	}
+	return results, nil
+}
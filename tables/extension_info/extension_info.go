@@ -0,0 +1,103 @@
+package extension_info
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nachorpaez/osquery-extensions/pkg/registry"
+	"github.com/nachorpaez/osquery-extensions/pkg/utils"
+	"github.com/osquery/osquery-go/plugin/table"
+)
+
+var (
+	mu         sync.RWMutex
+	startTime  = time.Now()
+	version    string
+	socketPath string
+	tableNames []string
+)
+
+func init() {
+	registry.Register(registry.Table{
+		Name:     "osquery_extension_info",
+		Columns:  ExtensionInfoColumns(),
+		Generate: ExtensionInfoGenerate,
+	})
+}
+
+// Configure sets the extension details reported by the table. tables are the
+// tables registered with osquery, every table in the registry is reported
+// when it is empty.
+func Configure(extensionVersion, extensionSocketPath string, tables []string) {
+	mu.Lock()
+	defer mu.Unlock()
+	version = extensionVersion
+	socketPath = extensionSocketPath
+	tableNames = tables
+}
+
+func ExtensionInfoColumns() []table.ColumnDefinition {
+	return []table.ColumnDefinition{
+		table.TextColumn("version"),
+		table.BigIntColumn("uptime"),
+		table.TextColumn("socket_path"),
+		table.TextColumn("registered_tables"),
+		table.TextColumn("table_name"),
+		table.BigIntColumn("invocations"),
+		table.DoubleColumn("last_duration_ms"),
+		table.DoubleColumn("p95_duration_ms"),
+		table.BigIntColumn("last_row_count"),
+		table.BigIntColumn("files_read"),
+		table.BigIntColumn("bytes_parsed"),
+		table.BigIntColumn("cache_hits"),
+		table.BigIntColumn("cache_misses"),
+		table.BigIntColumn("cache_entries"),
+		table.BigIntColumn("cache_bytes"),
+	}
+}
+
+func milliseconds(d time.Duration) string {
+	return strconv.FormatFloat(float64(d)/float64(time.Millisecond), 'f', 3, 64)
+}
+
+// Per docs generator function has to return an array of map of strings
+func ExtensionInfoGenerate(ctx context.Context, queryContext table.QueryContext) ([]map[string]string, error) {
+	mu.RLock()
+	names := tableNames
+	extensionVersion, extensionSocketPath := version, socketPath
+	mu.RUnlock()
+	if len(names) == 0 {
+		names = registry.Names()
+	}
+
+	var results []map[string]string
+	uptime := strconv.FormatInt(int64(time.Since(startTime).Seconds()), 10)
+	var cacheStats utils.CacheStats
+	if cache := utils.GetParsedCache(); cache != nil {
+		cacheStats = cache.Stats()
+	}
+	for _, name := range names {
+		stats := registry.GetStats(name)
+		results = append(results, map[string]string{
+			"version":           extensionVersion,
+			"uptime":            uptime,
+			"socket_path":       extensionSocketPath,
+			"registered_tables": strings.Join(names, ","),
+			"table_name":        name,
+			"invocations":       strconv.FormatInt(stats.Invocations, 10),
+			"last_duration_ms":  milliseconds(stats.LastDuration),
+			"p95_duration_ms":   milliseconds(stats.P95Duration),
+			"last_row_count":    strconv.Itoa(stats.LastRowCount),
+			"files_read":        strconv.FormatInt(stats.FilesRead, 10),
+			"bytes_parsed":      strconv.FormatInt(stats.BytesParsed, 10),
+			"cache_hits":        strconv.FormatInt(stats.CacheHits, 10),
+			"cache_misses":      strconv.FormatInt(stats.CacheMisses, 10),
+			"cache_entries":     strconv.Itoa(cacheStats.Entries),
+			"cache_bytes":       strconv.FormatInt(cacheStats.Bytes, 10),
+		})
+	}
+	return results, nil
+}
@@ -9,25 +9,87 @@ import (
 	"strconv"
 	"strings"
 
+	"github.com/nachorpaez/osquery-extensions/pkg/registry"
+	"github.com/nachorpaez/osquery-extensions/pkg/utils"
 	"github.com/osquery/osquery-go/plugin/table"
 	"github.com/pkg/errors"
 )
 
-var homeDirLocations = map[string]string{
-	"windows": "/Users",
-	"darwin":  "/Users",
-	"linux":   "/home/",
+// editorExtensionsDir is the extensions directory of a VS Code based editor,
+// relative to the user's home.
+type editorExtensionsDir struct {
+	editor string
+	path   string
 }
 
-var extensionsDir = map[string][]string{
-	"windows": {".vscode/extensions", ".vscode-server/extensions/"},
-	"darwin":  {".vscode/extensions", ".vscode-server/extensions/"},
-	"linux":   {".vscode/extensions", ".vscode-server/extensions/"},
+// vscodeExtensionsDirs covers VS Code, its forks and remote server installs.
+// They all use the same layout on every platform.
+var vscodeExtensionsDirs = []editorExtensionsDir{
+	{editor: "vscode", path: ".vscode/extensions"},
+	{editor: "vscode_insiders", path: ".vscode-insiders/extensions"},
+	{editor: "vscode_server", path: ".vscode-server/extensions/"},
+	{editor: "vscode_server_insiders", path: ".vscode-server-insiders/extensions/"},
+	{editor: "vscode_remote", path: ".vscode-remote/extensions/"},
+	{editor: "vscodium", path: ".vscode-oss/extensions"},
+	{editor: "cursor", path: ".cursor/extensions"},
+	{editor: "windsurf", path: ".windsurf/extensions"},
 }
 
+var extensionsDir = map[string][]editorExtensionsDir{
+	"windows": vscodeExtensionsDirs,
+	"darwin":  vscodeExtensionsDirs,
+	"linux":   vscodeExtensionsDirs,
+}
+
+// extensionsRegistryFile lists the extensions installed in an extensions directory
+const extensionsRegistryFile = "extensions.json"
+
+// obsoleteExtensionsFile lists the extension folders pending removal
+const obsoleteExtensionsFile = ".obsolete"
+
+// Extension statuses
+const (
+	statusInstalled = "installed"
+	statusObsolete  = "obsolete"
+	statusOrphaned  = "orphaned"
+)
+
 type userFileInfo struct {
-	user string
-	path string
+	user   string
+	uid    string
+	home   string
+	path   string
+	editor string
+}
+
+// ownerContext returns a context in which the files read must resolve in
+// the home directory of the user
+func (f userFileInfo) ownerContext(ctx context.Context) context.Context {
+	return utils.WithOwner(ctx, utils.UserAccount{Name: f.user, UID: f.uid, Home: f.home})
+}
+
+// RegistryEntry represents an extension registered in extensions.json
+type RegistryEntry struct {
+	Identifier struct {
+		ID   string `json:"id"`
+		UUID string `json:"uuid"`
+	} `json:"identifier"`
+	Version          string           `json:"version"`
+	Location         json.RawMessage  `json:"location"`
+	RelativeLocation string           `json:"relativeLocation"`
+	Metadata         RegistryMetadata `json:"metadata"`
+}
+
+type RegistryMetadata struct {
+	ID                   string `json:"id"`
+	PublisherID          string `json:"publisherId"`
+	PublisherDisplayName string `json:"publisherDisplayName"`
+	TargetPlatform       string `json:"targetPlatform"`
+	IsBuiltin            bool   `json:"isBuiltin"`
+	IsApplicationScoped  bool   `json:"isApplicationScoped"`
+	Pinned               bool   `json:"pinned"`
+	Source               string `json:"source"`
+	InstalledTimestamp   int64  `json:"installedTimestamp"`
 }
 
 type Extension struct {
@@ -54,6 +116,15 @@ type Metadata struct {
 	InstalledTimestamp   int64  `json:"installedTimestamp"`
 }
 
+func init() {
+	registry.Register(registry.Table{
+		Name:      "vscode_extensions",
+		Columns:   VSCodeColumns(),
+		Generate:  VSCodeExtGenerate,
+		Platforms: []string{"darwin", "linux", "windows"},
+	})
+}
+
 func VSCodeColumns() []table.ColumnDefinition {
 	return []table.ColumnDefinition{
 		table.TextColumn("name"),
@@ -70,19 +141,30 @@ func VSCodeColumns() []table.ColumnDefinition {
 		table.TextColumn("publisher_id"),
 		table.BigIntColumn("installed_at"),
 		table.TextColumn("user"),
+		table.BigIntColumn("uid"),
+		table.TextColumn("editor"),
+		table.TextColumn("status"),
+		table.IntegerColumn("is_builtin"),
+		table.IntegerColumn("is_application_scoped"),
+		table.TextColumn("target_platform"),
+		table.IntegerColumn("pinned"),
+		table.TextColumn("source"),
 	}
 }
 
 func parseExtension(ctx context.Context, fileInfo userFileInfo) (map[string]string, error) {
 	var results map[string]string
-	data, err := os.ReadFile(fileInfo.path)
+	extensionInfo, err := utils.ReadParsed(ctx, "vscode_extension_package", fileInfo.path, func(data []byte) (Extension, error) {
+		var extensionInfo Extension
+		err := json.Unmarshal(data, &extensionInfo)
+		return extensionInfo, err
+	})
+	if utils.IsParseError(err) {
+		return nil, errors.Wrap(err, "unmarshalling extension metadata file")
+	}
 	if err != nil {
 		return nil, errors.Wrap(err, "reading extension metadata file")
 	}
-	var extensionInfo Extension
-	if err := json.Unmarshal(data, &extensionInfo); err != nil {
-		return nil, errors.Wrap(err, "unmarshalling extension metadata file")
-	}
 
 	results = map[string]string{
 		"name":         extensionInfo.Name,
@@ -98,59 +180,263 @@ func parseExtension(ctx context.Context, fileInfo userFileInfo) (map[string]stri
 		"publisher":    extensionInfo.Metadata.PublisherDisplayName,
 		"publisher_id": extensionInfo.Metadata.PublisherID,
 		"user":         fileInfo.user,
+		"uid":          fileInfo.uid,
+		"editor":       fileInfo.editor,
 		"installed_at": strconv.FormatInt(extensionInfo.Metadata.InstalledTimestamp, 10),
+
+		// Filled from the extensions registry when available
+		"status":                "",
+		"is_builtin":            "",
+		"is_application_scoped": "",
+		"target_platform":       "",
+		"pinned":                "",
+		"source":                "",
+	}
+
+	return results, nil
+}
+
+// registryEntryDir returns the folder of a registered extension
+func registryEntryDir(extensionsDir string, entry RegistryEntry) string {
+	if entry.RelativeLocation != "" {
+		return filepath.Join(extensionsDir, entry.RelativeLocation)
+	}
+	var location struct {
+		FsPath string `json:"fsPath"`
+		Path   string `json:"path"`
+	}
+	if err := json.Unmarshal(entry.Location, &location); err != nil {
+		return ""
+	}
+	if location.FsPath != "" {
+		return utils.RootPath(location.FsPath)
+	}
+	return utils.RootPath(filepath.FromSlash(location.Path))
+}
+
+// readRegistry parses extensions.json. It returns a nil slice if the file
+// does not exist, as older VS Code versions don't write it.
+func readRegistry(ctx context.Context, extensionsDir string) ([]RegistryEntry, error) {
+	entries, err := utils.ReadParsed(ctx, "vscode_extensions_registry", filepath.Join(extensionsDir, extensionsRegistryFile),
+		func(data []byte) ([]RegistryEntry, error) {
+			entries := []RegistryEntry{}
+			err := json.Unmarshal(data, &entries)
+			return entries, err
+		})
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if utils.IsParseError(err) {
+		return nil, errors.Wrap(err, "unmarshalling extensions registry")
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "reading extensions registry")
+	}
+	return entries, nil
+}
+
+// readObsolete parses the .obsolete file, a map of folder names to true
+func readObsolete(ctx context.Context, extensionsDir string) map[string]bool {
+	obsolete, err := utils.ReadParsed(ctx, "vscode_extensions_obsolete", filepath.Join(extensionsDir, obsoleteExtensionsFile),
+		func(data []byte) (map[string]bool, error) {
+			obsolete := map[string]bool{}
+			err := json.Unmarshal(data, &obsolete)
+			return obsolete, err
+		})
+	if err != nil {
+		return map[string]bool{}
+	}
+	return obsolete
+}
+
+// parseExtensionsDir returns one row per extension registered in the
+// extensions directory, plus the folders on disk VS Code doesn't know about.
+func parseExtensionsDir(ctx context.Context, dirInfo userFileInfo) ([]map[string]string, error) {
+	var results []map[string]string
+
+	installed, err := readRegistry(ctx, dirInfo.path)
+	if err != nil {
+		return nil, err
+	}
+	obsolete := readObsolete(ctx, dirInfo.path)
+
+	registered := map[string]bool{}
+	for _, entry := range installed {
+		if err := ctx.Err(); err != nil {
+			return results, errors.Wrap(err, "extensions not read before the deadline")
+		}
+		extDir := registryEntryDir(dirInfo.path, entry)
+		registered[filepath.Clean(extDir)] = true
+
+		res, err := parseExtension(ctx, userFileInfo{
+			user:   dirInfo.user,
+			uid:    dirInfo.uid,
+			path:   filepath.Join(extDir, "package.json"),
+			editor: dirInfo.editor,
+		})
+		if err != nil {
+			utils.RecordError("vscode_extensions", dirInfo.user, filepath.Join(extDir, "package.json"), err)
+			continue
+		}
+
+		// An uninstalled extension stays registered until VS Code
+		// restarts and removes its folder
+		res["status"] = statusInstalled
+		if obsolete[filepath.Base(extDir)] {
+			res["status"] = statusObsolete
+		}
+		res["is_builtin"] = strconv.Itoa(utils.Btoi(entry.Metadata.IsBuiltin))
+		res["is_application_scoped"] = strconv.Itoa(utils.Btoi(entry.Metadata.IsApplicationScoped))
+		res["target_platform"] = entry.Metadata.TargetPlatform
+		res["pinned"] = strconv.Itoa(utils.Btoi(entry.Metadata.Pinned))
+		res["source"] = entry.Metadata.Source
+		if entry.Metadata.InstalledTimestamp != 0 {
+			res["installed_at"] = strconv.FormatInt(entry.Metadata.InstalledTimestamp, 10)
+		}
+		results = append(results, res)
+	}
+
+	packageFiles, err := utils.FileSystemFromContext(ctx).Glob(filepath.Join(dirInfo.path, "*", "package.json"))
+	if err != nil {
+		return results, nil
+	}
+	for _, packageFile := range packageFiles {
+		extDir := filepath.Dir(packageFile)
+		if registered[filepath.Clean(extDir)] {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return results, errors.Wrap(err, "extensions not read before the deadline")
+		}
+
+		res, err := parseExtension(ctx, userFileInfo{user: dirInfo.user, uid: dirInfo.uid, path: packageFile, editor: dirInfo.editor})
+		if err != nil {
+			utils.RecordError("vscode_extensions", dirInfo.user, packageFile, err)
+			continue
+		}
+		switch {
+		case obsolete[filepath.Base(extDir)]:
+			res["status"] = statusObsolete
+		case installed == nil:
+			// Without a registry every folder on disk is considered installed
+			res["status"] = statusInstalled
+		default:
+			res["status"] = statusOrphaned
+		}
+		results = append(results, res)
 	}
 
 	return results, nil
 }
 
+// fileInfoFromPath infers the user and editor of a package.json given
+// explicitly through a path constraint. They are left empty when the file is
+// not in a known location.
+func fileInfoFromPath(ctx context.Context, path string) userFileInfo {
+	fileInfo := userFileInfo{path: path}
+
+	account, userRelPath, found := utils.UserFromPath(ctx, path)
+	if !found {
+		return fileInfo
+	}
+	fileInfo.user = account.Name
+	fileInfo.uid = account.UID
+	fileInfo.home = account.Home
+
+	for _, extDir := range extensionsDir[runtime.GOOS] {
+		if strings.HasPrefix(userRelPath, filepath.Clean(extDir.path)+string(filepath.Separator)) {
+			fileInfo.editor = extDir.editor
+			break
+		}
+	}
+	return fileInfo
+}
+
+// parsePackageFile parses a package.json given explicitly. If it sits in an
+// extensions directory with a registry the registry status is returned too.
+func parsePackageFile(ctx context.Context, fileInfo userFileInfo) (map[string]string, error) {
+	dirInfo := fileInfo
+	dirInfo.path = filepath.Dir(filepath.Dir(fileInfo.path))
+	if _, err := utils.FileSystemFromContext(ctx).Stat(filepath.Join(dirInfo.path, extensionsRegistryFile)); err == nil {
+		res, err := parseExtensionsDir(ctx, dirInfo)
+		if err == nil {
+			for _, row := range res {
+				if row["path"] == fileInfo.path {
+					return row, nil
+				}
+			}
+		}
+	}
+	return parseExtension(ctx, fileInfo)
+}
+
+// NewVSCodeExtGenerate returns a generate function discovering and reading the
+// extensions on fsys instead of the host filesystem.
+func NewVSCodeExtGenerate(fsys utils.FileSystem) table.GenerateFunc {
+	return utils.GenerateWithFileSystem(fsys, VSCodeExtGenerate)
+}
+
 // Per docs generator function has to return an array of map of strings
 func VSCodeExtGenerate(ctx context.Context, queryContext table.QueryContext) ([]map[string]string, error) {
 	osExtensionsDir := extensionsDir[runtime.GOOS]
 	var results []map[string]string
-	for _, extDir := range osExtensionsDir {
-		userFiles, err := findFileInUserDirs(extDir)
-		if err != nil {
-			return results, nil
-		}
-		for _, file := range userFiles {
-			res, err := parseExtension(ctx, file)
+
+	// Parse the files given explicitly instead of looking in home directories
+	if paths := utils.GetConstraintValues(queryContext, "path"); len(paths) > 0 {
+		for _, path := range paths {
+			fileInfo := fileInfoFromPath(ctx, path)
+			res, err := parsePackageFile(fileInfo.ownerContext(ctx), fileInfo)
 			if err != nil {
+				utils.RecordError("vscode_extensions", fileInfo.user, path, err)
 				continue
 			}
 			results = append(results, res)
 		}
+		return results, nil
+	}
+	var dirs []userFileInfo
+	for _, extDir := range osExtensionsDir {
+		for _, dir := range findDirInUserDirs(ctx, extDir.path) {
+			dir.editor = extDir.editor
+			dirs = append(dirs, dir)
+		}
+	}
+	for i, dir := range dirs {
+		if ctx.Err() != nil {
+			// One error for the directories left rather than one per
+			// extension, the caller going away isn't an error of the table
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				utils.RecordError("vscode_extensions", dir.user, dir.path, errors.Wrapf(ctx.Err(), "%d extensions directories not read before the deadline", len(dirs)-i))
+			}
+			break
+		}
+		// The extensions read before the deadline are kept
+		res, err := parseExtensionsDir(dir.ownerContext(ctx), dir)
+		if err != nil {
+			utils.RecordError("vscode_extensions", dir.user, dir.path, err)
+		}
+		results = append(results, res...)
 	}
 
 	return results, nil
 }
 
-func findFileInUserDirs(extensions_location string) ([]userFileInfo, error) {
+// findDirInUserDirs returns the given directory for each user that has it.
+func findDirInUserDirs(ctx context.Context, location string) []userFileInfo {
+	fsys := utils.FileSystemFromContext(ctx)
 	foundPaths := []userFileInfo{}
 
-	homedirRoots, ok := homeDirLocations[runtime.GOOS]
-	if !ok {
-		return []userFileInfo{}, errors.New("No homedir location found for this GOOS")
-	}
-
-	userDirs, _ := os.ReadDir(homedirRoots)
-
-	// For each user's dir, in this possibleHome, check!
-	for _, ud := range userDirs {
-		userPathPattern := filepath.Join(homedirRoots, ud.Name(), extensions_location, "*/package.json")
-		fullPaths, err := filepath.Glob(userPathPattern)
-		if err != nil {
-			continue
-		}
-		for _, fullPath := range fullPaths {
-			if stat, err := os.Stat(fullPath); err == nil && stat.Mode().IsRegular() {
-				foundPaths = append(foundPaths, userFileInfo{
-					user: ud.Name(),
-					path: fullPath,
-				})
-			}
+	for _, account := range utils.ListUserAccounts(ctx) {
+		fullPath := filepath.Join(account.Home, location)
+		if stat, err := fsys.Stat(fullPath); err == nil && stat.IsDir() {
+			foundPaths = append(foundPaths, userFileInfo{
+				user: account.Name,
+				uid:  account.UID,
+				home: account.Home,
+				path: fullPath,
+			})
 		}
 	}
 
-	return foundPaths, nil
+	return foundPaths
 }
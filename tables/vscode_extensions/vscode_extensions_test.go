@@ -0,0 +1,234 @@
+package vscode_extensions
+
+import (
+	"context"
+	_ "embed"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/nachorpaez/osquery-extensions/pkg/utils"
+	"github.com/osquery/osquery-go/plugin/table"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+//go:embed test_extensions.json
+var testExtensionsRegistry []byte
+
+func writePackage(t *testing.T, extensionsDir, folder, content string) {
+	dir := filepath.Join(extensionsDir, folder)
+	require.NoError(t, os.MkdirAll(dir, 0700))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "package.json"), []byte(content), 0600))
+}
+
+func setupExtensionsDir(t *testing.T) string {
+	extensionsDir := t.TempDir()
+	writePackage(t, extensionsDir, "ms-python.python-2024.2.1-linux-x64",
+		`{"name": "python", "publisher": "ms-python", "version": "2024.2.1", "__metadata": {"publisherDisplayName": "Microsoft"}}`)
+	writePackage(t, extensionsDir, "acme.internal-tools-0.0.1",
+		`{"name": "internal-tools", "publisher": "acme", "version": "0.0.1"}`)
+	writePackage(t, extensionsDir, "ms-python.python-2024.1.0-linux-x64",
+		`{"name": "python", "publisher": "ms-python", "version": "2024.1.0"}`)
+	writePackage(t, extensionsDir, "evil.backdoor-1.0.0",
+		`{"name": "backdoor", "publisher": "evil", "version": "1.0.0"}`)
+	return extensionsDir
+}
+
+func TestParseExtensionsDir(t *testing.T) {
+	extensionsDir := setupExtensionsDir(t)
+	require.NoError(t, os.WriteFile(filepath.Join(extensionsDir, extensionsRegistryFile), testExtensionsRegistry, 0600))
+	require.NoError(t, os.WriteFile(filepath.Join(extensionsDir, obsoleteExtensionsFile),
+		[]byte(`{"ms-python.python-2024.1.0-linux-x64": true}`), 0600))
+
+	results, err := parseExtensionsDir(context.Background(), userFileInfo{user: "user1", path: extensionsDir, editor: "vscode"})
+	require.NoError(t, err)
+	require.Len(t, results, 4)
+
+	rows := map[string]map[string]string{}
+	for _, row := range results {
+		assert.Equal(t, "user1", row["user"])
+		assert.Equal(t, "vscode", row["editor"])
+		rows[row["identifier"]+"@"+row["version"]] = row
+	}
+
+	python := rows["ms-python.python@2024.2.1"]
+	assert.Equal(t, statusInstalled, python["status"])
+	assert.Equal(t, "linux-x64", python["target_platform"])
+	assert.Equal(t, "1", python["pinned"])
+	assert.Equal(t, "gallery", python["source"])
+	assert.Equal(t, "0", python["is_builtin"])
+	assert.Equal(t, "1709000000000", python["installed_at"])
+
+	vsix := rows["acme.internal-tools@0.0.1"]
+	assert.Equal(t, statusInstalled, vsix["status"])
+	assert.Equal(t, "vsix", vsix["source"])
+	assert.Equal(t, "1", vsix["is_application_scoped"])
+
+	assert.Equal(t, statusObsolete, rows["ms-python.python@2024.1.0"]["status"])
+	assert.Equal(t, statusOrphaned, rows["evil.backdoor@1.0.0"]["status"])
+}
+
+func TestParseExtensionsDirRegisteredObsolete(t *testing.T) {
+	// An extension uninstalled in this session is still registered
+	extensionsDir := setupExtensionsDir(t)
+	require.NoError(t, os.WriteFile(filepath.Join(extensionsDir, extensionsRegistryFile), testExtensionsRegistry, 0600))
+	require.NoError(t, os.WriteFile(filepath.Join(extensionsDir, obsoleteExtensionsFile),
+		[]byte(`{"acme.internal-tools-0.0.1": true}`), 0600))
+
+	results, err := parseExtensionsDir(context.Background(), userFileInfo{user: "user1", path: extensionsDir, editor: "vscode"})
+	require.NoError(t, err)
+	statuses := map[string]string{}
+	for _, row := range results {
+		statuses[row["identifier"]+"@"+row["version"]] = row["status"]
+	}
+	assert.Equal(t, map[string]string{
+		"ms-python.python@2024.2.1": statusInstalled,
+		"acme.internal-tools@0.0.1": statusObsolete,
+		"ms-python.python@2024.1.0": statusOrphaned,
+		"evil.backdoor@1.0.0":       statusOrphaned,
+	}, statuses)
+}
+
+func TestParseExtensionsDirWithoutRegistry(t *testing.T) {
+	extensionsDir := setupExtensionsDir(t)
+
+	results, err := parseExtensionsDir(context.Background(), userFileInfo{user: "user1", path: extensionsDir, editor: "cursor"})
+	require.NoError(t, err)
+	require.Len(t, results, 4)
+	for _, row := range results {
+		assert.Equal(t, statusInstalled, row["status"])
+		assert.Equal(t, "cursor", row["editor"])
+	}
+}
+
+func TestVSCodeExtGenerateWithPath(t *testing.T) {
+	extensionsDir := setupExtensionsDir(t)
+	require.NoError(t, os.WriteFile(filepath.Join(extensionsDir, extensionsRegistryFile), testExtensionsRegistry, 0600))
+
+	registered := filepath.Join(extensionsDir, "ms-python.python-2024.2.1-linux-x64", "package.json")
+	orphaned := filepath.Join(extensionsDir, "evil.backdoor-1.0.0", "package.json")
+	queryContext := table.QueryContext{
+		Constraints: map[string]table.ConstraintList{
+			"path": {Constraints: []table.Constraint{
+				{Operator: table.OperatorEquals, Expression: registered},
+				{Operator: table.OperatorEquals, Expression: orphaned},
+			}},
+		},
+	}
+
+	results, err := VSCodeExtGenerate(context.Background(), queryContext)
+	require.NoError(t, err)
+	require.Len(t, results, 2)
+
+	rows := map[string]map[string]string{}
+	for _, row := range results {
+		assert.Equal(t, "", row["user"])
+		assert.Equal(t, "", row["editor"])
+		rows[row["path"]] = row
+	}
+	assert.Equal(t, statusInstalled, rows[registered]["status"])
+	assert.Equal(t, "gallery", rows[registered]["source"])
+	assert.Equal(t, statusOrphaned, rows[orphaned]["status"])
+}
+
+func TestVSCodeExtGenerateOnFileSystem(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("fixture uses the Linux home directories")
+	}
+
+	generate := NewVSCodeExtGenerate(utils.NewFileSystem(fstest.MapFS{
+		"etc/passwd": {Data: []byte("alice:x:1000:1000::/home/alice:/bin/bash\nbob:x:1001:1001::/home/bob:/bin/bash\n")},
+		"home/alice/.vscode/extensions/ms-python.python-2024.2.1-linux-x64/package.json": {
+			Data: []byte(`{"name": "python", "publisher": "ms-python", "version": "2024.2.1"}`),
+		},
+		"home/alice/.cursor/extensions/acme.internal-tools-0.0.1/package.json": {
+			Data: []byte(`{"name": "internal-tools", "publisher": "acme", "version": "0.0.1"}`),
+		},
+		"home/bob/.vscode-server/extensions/evil.backdoor-1.0.0/package.json": {
+			Data: []byte(`{"name": "backdoor", "publisher": "evil", "version": "1.0.0"}`),
+		},
+	}))
+
+	results, err := generate(context.Background(), table.QueryContext{})
+	require.NoError(t, err)
+
+	var found []string
+	for _, row := range results {
+		assert.Equal(t, statusInstalled, row["status"])
+		found = append(found, row["user"]+"/"+row["uid"]+"/"+row["editor"]+"/"+row["name"])
+	}
+	assert.ElementsMatch(t, []string{
+		"alice/1000/vscode/python",
+		"alice/1000/cursor/internal-tools",
+		"bob/1001/vscode_server/backdoor",
+	}, found)
+}
+
+// slowFileSystem takes longer than the deadline to open the first file named
+// name
+type slowFileSystem struct {
+	utils.FileSystem
+	name string
+	once sync.Once
+}
+
+func (s *slowFileSystem) wait(path string) {
+	if filepath.Base(path) == s.name {
+		s.once.Do(func() { time.Sleep(200 * time.Millisecond) })
+	}
+}
+
+func (s *slowFileSystem) Open(name string) (fs.File, error) {
+	s.wait(name)
+	return s.FileSystem.Open(name)
+}
+
+func (s *slowFileSystem) OpenInDir(dir, name string) (fs.File, error) {
+	s.wait(name)
+	return s.FileSystem.OpenInDir(dir, name)
+}
+
+func TestVSCodeExtGenerateDeadline(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("fixture uses the Linux home directories")
+	}
+
+	generate := NewVSCodeExtGenerate(&slowFileSystem{name: "package.json", FileSystem: utils.NewFileSystem(fstest.MapFS{
+		"etc/passwd": {Data: []byte("alice:x:1000:1000::/home/alice:/bin/bash\nbob:x:1001:1001::/home/bob:/bin/bash\n")},
+		"home/alice/.vscode/extensions/ms-python.python-2024.2.1-linux-x64/package.json": {
+			Data: []byte(`{"name": "python", "publisher": "ms-python", "version": "2024.2.1"}`),
+		},
+		"home/alice/.vscode/extensions/golang.go-0.41.0/package.json": {
+			Data: []byte(`{"name": "go", "publisher": "golang", "version": "0.41.0"}`),
+		},
+		"home/alice/.cursor/extensions/acme.internal-tools-0.0.1/package.json": {
+			Data: []byte(`{"name": "internal-tools", "publisher": "acme", "version": "0.0.1"}`),
+		},
+		"home/bob/.vscode-server/extensions/evil.backdoor-1.0.0/package.json": {
+			Data: []byte(`{"name": "backdoor", "publisher": "evil", "version": "1.0.0"}`),
+		},
+	})})
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	before := len(utils.RecentErrors())
+	results, err := generate(ctx, table.QueryContext{})
+	require.NoError(t, err)
+	// The extension read past the deadline is kept, the others aren't read
+	require.Len(t, results, 1)
+	var timeouts []string
+	for _, e := range utils.RecentErrors()[before:] {
+		if e.Table == "vscode_extensions" {
+			assert.Equal(t, utils.ErrorClassTimeout, e.Class)
+			timeouts = append(timeouts, e.User+":"+e.Path)
+		}
+	}
+	// One error for the rest of alice's directory, one for the others
+	assert.Equal(t, []string{"alice:/home/alice/.vscode/extensions", "bob:/home/bob/.vscode-server/extensions"}, timeouts)
+}
@@ -0,0 +1,305 @@
+package vscode_settings
+
+import (
+	"context"
+	"encoding/json"
+	"net/url"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+
+	"github.com/nachorpaez/osquery-extensions/pkg/registry"
+	"github.com/nachorpaez/osquery-extensions/pkg/utils"
+	"github.com/osquery/osquery-go/plugin/table"
+	"github.com/pkg/errors"
+)
+
+// Settings scopes
+const (
+	scopeUser      = "user"
+	scopeMachine   = "machine"
+	scopeWorkspace = "workspace"
+)
+
+// editorDataDir is the data directory of a VS Code based editor, relative to
+// the user's home.
+type editorDataDir struct {
+	editor string
+	path   string
+}
+
+// userDataDirs maps each platform to the user data directory of every
+// VS Code based editor. User settings live in <dir>/User/settings.json.
+var userDataDirs = map[string][]editorDataDir{
+	"windows": {
+		{editor: "vscode", path: "AppData/Roaming/Code"},
+		{editor: "vscode_insiders", path: "AppData/Roaming/Code - Insiders"},
+		{editor: "vscodium", path: "AppData/Roaming/VSCodium"},
+		{editor: "cursor", path: "AppData/Roaming/Cursor"},
+		{editor: "windsurf", path: "AppData/Roaming/Windsurf"},
+	},
+	"darwin": {
+		{editor: "vscode", path: "Library/Application Support/Code"},
+		{editor: "vscode_insiders", path: "Library/Application Support/Code - Insiders"},
+		{editor: "vscodium", path: "Library/Application Support/VSCodium"},
+		{editor: "cursor", path: "Library/Application Support/Cursor"},
+		{editor: "windsurf", path: "Library/Application Support/Windsurf"},
+	},
+	"linux": {
+		{editor: "vscode", path: ".config/Code"},
+		{editor: "vscode_insiders", path: ".config/Code - Insiders"},
+		{editor: "vscodium", path: ".config/VSCodium"},
+		{editor: "cursor", path: ".config/Cursor"},
+		{editor: "windsurf", path: ".config/Windsurf"},
+	},
+}
+
+// serverDataDirs are the data directories of VS Code remote servers. Their
+// settings live in <dir>/Machine/settings.json.
+var serverDataDirs = []editorDataDir{
+	{editor: "vscode_server", path: ".vscode-server/data"},
+	{editor: "vscode_server_insiders", path: ".vscode-server-insiders/data"},
+}
+
+type settingsFile struct {
+	user      string
+	uid       string
+	home      string // directory the file must resolve in
+	editor    string
+	scope     string
+	path      string
+	workspace string
+}
+
+func init() {
+	registry.Register(registry.Table{
+		Name:      "vscode_settings",
+		Columns:   VSCodeSettingsColumns(),
+		Generate:  VSCodeSettingsGenerate,
+		Platforms: []string{"darwin", "linux", "windows"},
+	})
+}
+
+func VSCodeSettingsColumns() []table.ColumnDefinition {
+	return []table.ColumnDefinition{
+		table.TextColumn("key"),
+		table.TextColumn("value"),
+		table.TextColumn("type"),
+		table.TextColumn("scope"),
+		table.TextColumn("workspace"),
+		table.TextColumn("path"),
+		table.TextColumn("editor"),
+		table.TextColumn("user"),
+		table.BigIntColumn("uid"),
+	}
+}
+
+// flattenSettings flattens nested objects into dotted keys, e.g.
+// terminal.integrated.env.linux: {"FOO": "bar"} becomes
+// terminal.integrated.env.linux.FOO = bar. Arrays are kept as JSON.
+func flattenSettings(prefix string, value interface{}, output map[string]interface{}) {
+	dict, ok := value.(map[string]interface{})
+	if !ok || (len(dict) == 0 && prefix != "") {
+		output[prefix] = value
+		return
+	}
+	for k, v := range dict {
+		key := k
+		if prefix != "" {
+			key = prefix + "." + k
+		}
+		flattenSettings(key, v, output)
+	}
+}
+
+// settingValue returns the string representation and JSON type of a value
+func settingValue(value interface{}) (string, string) {
+	switch v := value.(type) {
+	case nil:
+		return "", "null"
+	case string:
+		return v, "string"
+	case bool:
+		return strconv.FormatBool(v), "boolean"
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), "number"
+	case []interface{}:
+		encoded, _ := json.Marshal(v)
+		return string(encoded), "array"
+	default:
+		encoded, _ := json.Marshal(v)
+		return string(encoded), "object"
+	}
+}
+
+func parseSettings(ctx context.Context, file settingsFile) ([]map[string]string, error) {
+	var results []map[string]string
+	settings, err := utils.ReadParsed(ctx, "vscode_settings", file.path, func(data []byte) (map[string]interface{}, error) {
+		var settings map[string]interface{}
+		err := utils.UnmarshalJSONC(data, &settings)
+		return settings, err
+	})
+	if utils.IsParseError(err) {
+		return nil, errors.Wrap(err, "unmarshalling settings file")
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "reading settings file")
+	}
+
+	flattened := map[string]interface{}{}
+	flattenSettings("", settings, flattened)
+
+	keys := make([]string, 0, len(flattened))
+	for k := range flattened {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		value, valueType := settingValue(flattened[key])
+		results = append(results, map[string]string{
+			"key":       key,
+			"value":     value,
+			"type":      valueType,
+			"scope":     file.scope,
+			"workspace": file.workspace,
+			"path":      file.path,
+			"editor":    file.editor,
+			"user":      file.user,
+			"uid":       file.uid,
+		})
+	}
+	return results, nil
+}
+
+// vscodeWorkspace is the content of a workspace.json file
+type vscodeWorkspace struct {
+	Folder string `json:"folder"`
+}
+
+// workspaceFolder converts the folder URI stored in a workspace.json file to
+// a local path. Remote folders are ignored.
+func workspaceFolder(ctx context.Context, workspaceFile string) (string, error) {
+	workspace, err := utils.ReadParsed(ctx, "vscode_workspace", workspaceFile, func(data []byte) (vscodeWorkspace, error) {
+		var workspace vscodeWorkspace
+		err := json.Unmarshal(data, &workspace)
+		return workspace, err
+	})
+	if utils.IsParseError(err) {
+		return "", errors.Wrap(err, "unmarshalling workspace file")
+	}
+	if err != nil {
+		return "", errors.Wrap(err, "reading workspace file")
+	}
+
+	folderURL, err := url.Parse(workspace.Folder)
+	if err != nil || folderURL.Scheme != "file" {
+		return "", errors.New("not a local workspace folder")
+	}
+	folder := folderURL.Path
+	// Windows paths are stored as /c:/Users/...
+	if len(folder) > 2 && folder[0] == '/' && folder[2] == ':' {
+		folder = folder[1:]
+	}
+	return filepath.FromSlash(folder), nil
+}
+
+// findSettingsFiles returns the user, machine and workspace settings files of
+// every VS Code based editor.
+func findSettingsFiles(ctx context.Context) []settingsFile {
+	var files []settingsFile
+
+	for _, dataDir := range userDataDirs[runtime.GOOS] {
+		userFiles, err := utils.FindFileInUserDirs(ctx, filepath.Join(dataDir.path, "User", "settings.json"))
+		if err == nil {
+			for _, f := range userFiles {
+				files = append(files, settingsFile{user: f.User, uid: f.UID, home: f.Home, editor: dataDir.editor, scope: scopeUser, path: f.Path})
+			}
+		}
+
+		// Workspaces opened by the user are recorded in workspaceStorage
+		workspaceFiles, err := utils.FindFileInUserDirs(ctx, filepath.Join(dataDir.path, "User", "workspaceStorage", "*", "workspace.json"))
+		if err != nil {
+			continue
+		}
+		seen := map[string]bool{}
+		for _, f := range workspaceFiles {
+			// The files found so far are read until the deadline
+			if ctx.Err() != nil {
+				break
+			}
+			owner := utils.UserAccount{Name: f.User, UID: f.UID, Home: f.Home}
+			folder, err := workspaceFolder(utils.WithOwner(ctx, owner), f.Path)
+			if err != nil || seen[f.User+folder] {
+				continue
+			}
+			seen[f.User+folder] = true
+
+			folderPath := utils.RootPath(folder)
+			settingsPath := filepath.Join(folderPath, ".vscode", "settings.json")
+			if !utils.FileExists(ctx, settingsPath) {
+				continue
+			}
+			// The settings of a workspace stay in its folder, which
+			// may be outside of the home directory, e.g. in /srv. The
+			// folder must then belong to the user, the URI can point
+			// anywhere.
+			if err := utils.CheckOwner(ctx, owner, folderPath); err != nil {
+				utils.RecordError("vscode_settings", f.User, folderPath, err)
+				continue
+			}
+			files = append(files, settingsFile{
+				user:      f.User,
+				uid:       f.UID,
+				home:      folderPath,
+				editor:    dataDir.editor,
+				scope:     scopeWorkspace,
+				path:      settingsPath,
+				workspace: folder,
+			})
+		}
+	}
+
+	for _, dataDir := range serverDataDirs {
+		machineFiles, err := utils.FindFileInUserDirs(ctx, filepath.Join(dataDir.path, "Machine", "settings.json"))
+		if err != nil {
+			continue
+		}
+		for _, f := range machineFiles {
+			files = append(files, settingsFile{user: f.User, uid: f.UID, home: f.Home, editor: dataDir.editor, scope: scopeMachine, path: f.Path})
+		}
+	}
+
+	return files
+}
+
+// NewVSCodeSettingsGenerate returns a generate function discovering and reading
+// the settings files on fsys instead of the host filesystem.
+func NewVSCodeSettingsGenerate(fsys utils.FileSystem) table.GenerateFunc {
+	return utils.GenerateWithFileSystem(fsys, VSCodeSettingsGenerate)
+}
+
+// Per docs generator function has to return an array of map of strings
+func VSCodeSettingsGenerate(ctx context.Context, queryContext table.QueryContext) ([]map[string]string, error) {
+	var results []map[string]string
+	files := findSettingsFiles(ctx)
+	for i, file := range files {
+		if ctx.Err() != nil {
+			// One error for the files left rather than one per file, the
+			// caller going away isn't an error of the table
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				utils.RecordError("vscode_settings", file.user, file.path, errors.Wrapf(ctx.Err(), "%d settings files not read before the deadline", len(files)-i))
+			}
+			break
+		}
+		fileCtx := utils.WithOwner(ctx, utils.UserAccount{Name: file.user, UID: file.uid, Home: file.home})
+		res, err := parseSettings(fileCtx, file)
+		if err != nil {
+			utils.RecordError("vscode_settings", file.user, file.path, err)
+			continue
+		}
+		results = append(results, res...)
+	}
+	return results, nil
+}
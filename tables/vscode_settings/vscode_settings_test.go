@@ -0,0 +1,207 @@
+package vscode_settings
+
+import (
+	"context"
+	_ "embed"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/nachorpaez/osquery-extensions/pkg/utils"
+	"github.com/osquery/osquery-go/plugin/table"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+//go:embed test_settings.json
+var testSettings []byte
+
+func TestParseSettings(t *testing.T) {
+	settingsPath := filepath.Join(t.TempDir(), "settings.json")
+	require.NoError(t, os.WriteFile(settingsPath, testSettings, 0600))
+
+	file := settingsFile{user: "user1", uid: "1001", editor: "cursor", scope: scopeUser, path: settingsPath}
+	results, err := parseSettings(context.Background(), file)
+	require.NoError(t, err)
+
+	newRow := func(key, value, valueType string) map[string]string {
+		return map[string]string{
+			"key":       key,
+			"value":     value,
+			"type":      valueType,
+			"scope":     "user",
+			"workspace": "",
+			"path":      settingsPath,
+			"editor":    "cursor",
+			"user":      "user1",
+			"uid":       "1001",
+		}
+	}
+
+	assert.Equal(t, []map[string]string{
+		newRow("editor.fontSize", "13.5", "number"),
+		newRow("extensions.autoUpdate", "false", "boolean"),
+		newRow("files.exclude", "{}", "object"),
+		newRow("git.ignoredRepositories", `["/srv/secret"]`, "array"),
+		newRow("http.proxy", "http://proxy.example.com:3128", "string"),
+		newRow("security.workspace.trust.enabled", "false", "boolean"),
+		newRow("telemetry.telemetryLevel", "off", "string"),
+		newRow("terminal.integrated.env.linux.LD_PRELOAD", "/tmp/libhook.so", "string"),
+	}, results)
+}
+
+func TestWorkspaceFolder(t *testing.T) {
+	tempDir := t.TempDir()
+	tests := map[string]string{
+		`{"folder": "file:///home/user1/src/my%20project"}`: filepath.FromSlash("/home/user1/src/my project"),
+		`{"folder": "file:///c%3A/Users/user1/src"}`:        filepath.FromSlash("c:/Users/user1/src"),
+	}
+	for content, expected := range tests {
+		workspaceFile := filepath.Join(tempDir, "workspace.json")
+		require.NoError(t, os.WriteFile(workspaceFile, []byte(content), 0600))
+		folder, err := workspaceFolder(context.Background(), workspaceFile)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, folder)
+	}
+
+	workspaceFile := filepath.Join(tempDir, "workspace.json")
+	require.NoError(t, os.WriteFile(workspaceFile, []byte(`{"folder": "vscode-remote://ssh-remote+host/home/user1"}`), 0600))
+	_, err := workspaceFolder(context.Background(), workspaceFile)
+	assert.Error(t, err)
+}
+
+func TestVSCodeSettingsGenerateOnFileSystem(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("fixture uses the Linux directory layout")
+	}
+
+	generate := NewVSCodeSettingsGenerate(utils.NewFileSystem(fstest.MapFS{
+		"etc/passwd": {Data: []byte("alice:x:1000:1000::/home/alice:/bin/bash\nbob:x:1001:1001::/home/bob:/bin/bash\n")},
+		"home/alice/.config/Code/User/settings.json":         {Data: []byte(`{"http.proxy": "http://proxy.example.com:3128"}`)},
+		"home/alice/.config/Cursor/User/settings.json":       {Data: []byte(`{"editor.fontSize": 13}`)},
+		"home/bob/.vscode-server/data/Machine/settings.json": {Data: []byte(`{"security.workspace.trust.enabled": false}`)},
+		"home/bob/.config/Code/User/workspaceStorage/1/workspace.json": {
+			Data: []byte(`{"folder": "file:///srv/project"}`),
+		},
+		"srv/project/.vscode/settings.json": {Data: []byte(`{"python.defaultInterpreterPath": "/tmp/python"}`)},
+	}))
+
+	results, err := generate(context.Background(), table.QueryContext{})
+	require.NoError(t, err)
+
+	var found []string
+	for _, row := range results {
+		found = append(found, row["user"]+"/"+row["uid"]+"/"+row["editor"]+"/"+row["scope"]+"/"+row["key"])
+	}
+	assert.ElementsMatch(t, []string{
+		"alice/1000/vscode/user/http.proxy",
+		"alice/1000/cursor/user/editor.fontSize",
+		"bob/1001/vscode_server/machine/security.workspace.trust.enabled",
+		"bob/1001/vscode/workspace/python.defaultInterpreterPath",
+	}, found)
+}
+
+func TestVSCodeSettingsWorkspaceOwner(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("fixture uses the Linux directory layout")
+	}
+	root := t.TempDir()
+	utils.SetRootDir(root)
+	t.Cleanup(func() { utils.SetRootDir("") })
+
+	// Both users opened /srv/project, which belongs to alice only
+	uid := strconv.Itoa(os.Getuid())
+	passwd := "alice:x:" + uid + ":1000::/home/alice:/bin/bash\nbob:x:4242:4242::/home/bob:/bin/bash\n"
+	files := map[string]string{
+		"etc/passwd":                        passwd,
+		"srv/project/.vscode/settings.json": `{"python.defaultInterpreterPath": "/tmp/python"}`,
+		"home/alice/.config/Code/User/workspaceStorage/1/workspace.json": `{"folder": "file:///srv/project"}`,
+		"home/bob/.config/Code/User/workspaceStorage/1/workspace.json":   `{"folder": "file:///srv/project"}`,
+	}
+	for name, content := range files {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0700))
+		require.NoError(t, os.WriteFile(path, []byte(content), 0600))
+	}
+
+	before := len(utils.RecentErrors())
+	results, err := VSCodeSettingsGenerate(context.Background(), table.QueryContext{})
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	assert.Equal(t, "alice", results[0]["user"])
+	assert.Equal(t, "/srv/project", results[0]["workspace"])
+
+	// bob's workspace is refused rather than silently skipped
+	var refusals []utils.TableError
+	for _, e := range utils.RecentErrors()[before:] {
+		if e.Table == "vscode_settings" {
+			refusals = append(refusals, e)
+		}
+	}
+	require.Len(t, refusals, 1)
+	assert.Equal(t, "bob", refusals[0].User)
+	assert.Equal(t, filepath.Join(root, "srv", "project"), refusals[0].Path)
+	assert.Equal(t, utils.ErrorClassRefused, refusals[0].Class)
+}
+
+// slowFileSystem takes longer than the deadline to open the first file named
+// name
+type slowFileSystem struct {
+	utils.FileSystem
+	name string
+	once sync.Once
+}
+
+func (s *slowFileSystem) wait(path string) {
+	if filepath.Base(path) == s.name {
+		s.once.Do(func() { time.Sleep(200 * time.Millisecond) })
+	}
+}
+
+func (s *slowFileSystem) Open(name string) (fs.File, error) {
+	s.wait(name)
+	return s.FileSystem.Open(name)
+}
+
+func (s *slowFileSystem) OpenInDir(dir, name string) (fs.File, error) {
+	s.wait(name)
+	return s.FileSystem.OpenInDir(dir, name)
+}
+
+func TestVSCodeSettingsGenerateDeadline(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("fixture uses the Linux directory layout")
+	}
+
+	generate := NewVSCodeSettingsGenerate(&slowFileSystem{name: "settings.json", FileSystem: utils.NewFileSystem(fstest.MapFS{
+		"etc/passwd": {Data: []byte("alice:x:1000:1000::/home/alice:/bin/bash\nbob:x:1001:1001::/home/bob:/bin/bash\n")},
+		"home/alice/.config/Code/User/settings.json":         {Data: []byte(`{"http.proxy": "http://proxy.example.com:3128"}`)},
+		"home/alice/.config/Cursor/User/settings.json":       {Data: []byte(`{"editor.fontSize": 13}`)},
+		"home/bob/.vscode-server/data/Machine/settings.json": {Data: []byte(`{"security.workspace.trust.enabled": false}`)},
+	})})
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	before := len(utils.RecentErrors())
+	results, err := generate(ctx, table.QueryContext{})
+	require.NoError(t, err)
+	// The file read past the deadline is kept, the others aren't read
+	require.Len(t, results, 1)
+	assert.Equal(t, "http.proxy", results[0]["key"])
+	var timeouts []string
+	for _, e := range utils.RecentErrors()[before:] {
+		if e.Table == "vscode_settings" {
+			assert.Equal(t, utils.ErrorClassTimeout, e.Class)
+			timeouts = append(timeouts, e.Message)
+		}
+	}
+	require.Len(t, timeouts, 1)
+	assert.True(t, strings.HasPrefix(timeouts[0], "2 settings files not read before the deadline"), timeouts[0])
+}